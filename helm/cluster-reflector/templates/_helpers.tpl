@@ -97,19 +97,13 @@ Create the container image reference
 
 {{/*
 Create container arguments
+Everything else is configured through the env ConfigMap
 */}}
 {{- define "cluster-reflector.args" -}}
 - --listen=:8080
-- --cache-ttl={{ .Values.cache.ttl }}
-{{- if .Values.appDiscovery.namespaceSelector }}
-- --namespace-selector={{ .Values.appDiscovery.namespaceSelector }}
+{{- if .Values.configFile }}
+- --config=/etc/reflector/config.yaml
 {{- end }}
-- --prefer-crd={{ .Values.appDiscovery.preferCRD }}
-- --fallback-workloads={{ .Values.appDiscovery.fallbackWorkloads }}
-{{- if .Values.appDiscovery.crdOnly }}
-- --crd-only={{ .Values.appDiscovery.crdOnly }}
-{{- end }}
-- --log-level={{ .Values.logLevel }}
 {{- end }}
 
 {{/*
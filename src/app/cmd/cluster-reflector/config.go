@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix is the prefix for environment variables that configure the binary
+const envPrefix = "CLUSTER_REFLECTOR_"
+
+// serviceLinkEnv matches the variables Kubernetes injects for each Service in
+// the namespace, which a Service named cluster-reflector gives our prefix
+var serviceLinkEnv = regexp.MustCompile(`_(SERVICE_HOST|SERVICE_PORT(_[A-Z0-9_]+)?|PORT(_[0-9]+_(TCP|UDP|SCTP)(_(PROTO|PORT|ADDR))?)?)$`)
+
+// legacyEnvNames maps flag names to the environment variables emitted by the
+// Helm chart's env ConfigMap
+var legacyEnvNames = map[string]string{
+	"cache-ttl":            "CACHE_TTL",
+	"log-level":            "LOG_LEVEL",
+	"app-discovery":        "APP_DISCOVERY_ENABLED",
+	"prefer-crd":           "APP_DISCOVERY_PREFER_CRD",
+	"fallback-workloads":   "APP_DISCOVERY_FALLBACK_WORKLOADS",
+	"crd-only":             "APP_DISCOVERY_CRD_ONLY",
+	"appversion-status":    "APP_DISCOVERY_APPVERSION_STATUS",
+	"generate-appversions": "APP_DISCOVERY_GENERATE_APPVERSIONS",
+	"namespace-selector":   "APP_DISCOVERY_NAMESPACE_SELECTOR",
+	"namespace-include":    "APP_DISCOVERY_NAMESPACE_INCLUDE",
+	"namespace-exclude":    "APP_DISCOVERY_NAMESPACE_EXCLUDE",
+	"workload-kinds":       "WORKLOAD_KINDS",
+	"workload-resources":   "WORKLOAD_RESOURCES",
+	"pod-versions":         "APP_DISCOVERY_POD_VERSIONS",
+	"app-scope":            "APP_DISCOVERY_APP_SCOPE",
+	"primary-version":      "APP_DISCOVERY_PRIMARY_VERSION",
+	"image-name":           "APP_DISCOVERY_IMAGE_NAME",
+	"image-name-pattern":   "APP_DISCOVERY_IMAGE_NAME_PATTERN",
+	"extraction-rules":     "APP_DISCOVERY_EXTRACTION_RULES",
+	"fail-before-ready":    "CACHE_FAIL_BEFORE_READY",
+	"max-staleness":        "CACHE_MAX_STALENESS",
+	"embed-sources":        "CACHE_EMBED_SOURCES",
+	"history-size":         "HISTORY_SIZE",
+	"history-store":        "HISTORY_STORE",
+	"history-file":         "HISTORY_FILE",
+	"history-configmap":    "HISTORY_CONFIGMAP",
+	"clusters":             "HUB_CLUSTERS",
+	"node-details":         "NODE_DETAILS",
+	"node-role-rules":      "NODE_ROLE_RULES",
+	"node-ip-preference":   "NODE_IP_PREFERENCE",
+	"cluster-name":         "CLUSTER_NAME",
+	"api-groups":           "CLUSTER_API_GROUPS",
+}
+
+// validLogLevels lists the accepted --log-level values
+var validLogLevels = []string{"debug", "info", "warn", "warning", "error"}
+
+// envName returns the prefixed environment variable name for a flag
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// resolveConfig fills in flags that were not set on the command line from the
+// environment and the config file. Precedence is flag, then CLUSTER_REFLECTOR_*
+// variable, then the config file, then the chart's legacy variable name. The
+// chart always renders its variables, so they rank below the config file for
+// its values to apply and be reloaded. It returns where each setting came from.
+func resolveConfig(cmd *cobra.Command, fileValues map[string]string) (map[string]string, error) {
+	sources := make(map[string]string)
+	var errs []string
+
+	flags := cmd.Flags()
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" || f.Name == "config" {
+			return
+		}
+		if flags.Changed(f.Name) {
+			sources[f.Name] = "flag"
+			return
+		}
+
+		env := envName(f.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			if err := flags.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s=%q: %v", env, value, err))
+			}
+			sources[f.Name] = "env:" + env
+			return
+		}
+
+		if value, ok := fileValues[f.Name]; ok {
+			if err := flags.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("config file %s=%q: %v", f.Name, value, err))
+			}
+			sources[f.Name] = "file"
+			return
+		}
+
+		if legacy, ok := legacyEnvNames[f.Name]; ok {
+			if value, ok := os.LookupEnv(legacy); ok {
+				if err := flags.Set(f.Name, value); err != nil {
+					errs = append(errs, fmt.Sprintf("%s=%q: %v", legacy, value, err))
+				}
+				sources[f.Name] = "chart:" + legacy
+				return
+			}
+		}
+
+		sources[f.Name] = "default"
+	})
+
+	// Reject prefixed variables that do not match any flag, they are almost
+	// certainly typos that would otherwise be silently ignored. Service links
+	// are not ours and are skipped.
+	known := make(map[string]bool)
+	for _, fs := range []*pflag.FlagSet{cmd.Root().Flags(), flags} {
+		fs.VisitAll(func(f *pflag.Flag) {
+			known[envName(f.Name)] = true
+		})
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, envPrefix) && !known[name] && !serviceLinkEnv.MatchString(name) {
+			errs = append(errs, fmt.Sprintf("unknown environment variable %s", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return sources, nil
+}
+
+// chartEnvValues returns the values of the chart's legacy variables that are
+// set, keyed by flag name
+func chartEnvValues() map[string]string {
+	values := make(map[string]string)
+	for flag, env := range legacyEnvNames {
+		if value, ok := os.LookupEnv(env); ok {
+			values[flag] = value
+		}
+	}
+	return values
+}
+
+// validateServerConfig rejects flag values the server cannot run with
+func validateServerConfig(cfg *types.Config) error {
+	if cfg.CacheTTL <= 0 {
+		return fmt.Errorf("invalid configuration: cache-ttl must be positive, got %s", cfg.CacheTTL)
+	}
+	if cfg.MaxStaleness < 0 {
+		return fmt.Errorf("invalid configuration: max-staleness must not be negative, got %s", cfg.MaxStaleness)
+	}
+
+	level := strings.ToLower(cfg.LogLevel)
+	for _, valid := range validLogLevels {
+		if level == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid configuration: unknown log-level %q (valid: %s)", cfg.LogLevel, strings.Join(validLogLevels, ", "))
+}
+
+// rulesValue is a flag holding rules, such as extraction or node role
+// rules, as a JSON or YAML list
+type rulesValue[T any] struct {
+	name  string
+	rules *[]T
+}
+
+func (v *rulesValue[T]) String() string {
+	if v.rules == nil || len(*v.rules) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(*v.rules)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (v *rulesValue[T]) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		*v.rules = nil
+		return nil
+	}
+
+	var rules []T
+	if err := yaml.UnmarshalStrict([]byte(value), &rules); err != nil {
+		return fmt.Errorf("invalid %s: %w", v.name, err)
+	}
+	*v.rules = rules
+	return nil
+}
+
+func (v *rulesValue[T]) Type() string {
+	return "json"
+}
+
+// logConfigSources logs where each setting was resolved from
+func logConfigSources(logger *logrus.Logger, sources map[string]string) {
+	fields := logrus.Fields{}
+	for flag, source := range sources {
+		fields[flag] = source
+	}
+	logger.WithFields(fields).Info("Resolved configuration sources")
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+// Polling is used rather than filesystem events because ConfigMap volumes
+// are updated by swapping a symlink, which inotify-style watches miss.
+const configPollInterval = 5 * time.Second
+
+// fileKeys maps config file keys onto flag names. Nested sections are
+// flattened with dots, so discovery.crdOnly is "discovery: {crdOnly: ...}".
+var fileKeys = map[string]string{
+	"listen":                        "listen",
+	"cacheTTL":                      "cache-ttl",
+	"logLevel":                      "log-level",
+	"metrics":                       "metrics",
+	"failBeforeReady":               "fail-before-ready",
+	"maxStaleness":                  "max-staleness",
+	"embedSources":                  "embed-sources",
+	"history.size":                  "history-size",
+	"history.store":                 "history-store",
+	"history.file":                  "history-file",
+	"history.configMap":             "history-configmap",
+	"hub.clusters":                  "clusters",
+	"nodes.details":                 "node-details",
+	"nodes.roleRules":               "node-role-rules",
+	"nodes.ipPreference":            "node-ip-preference",
+	"cluster.name":                  "cluster-name",
+	"cluster.apiGroups":             "api-groups",
+	"discovery.enabled":             "app-discovery",
+	"discovery.preferCRD":           "prefer-crd",
+	"discovery.fallbackWorkloads":   "fallback-workloads",
+	"discovery.crdOnly":             "crd-only",
+	"discovery.appVersionStatus":    "appversion-status",
+	"discovery.generateAppVersions": "generate-appversions",
+	"discovery.namespaceSelector":   "namespace-selector",
+	"discovery.namespaceInclude":    "namespace-include",
+	"discovery.namespaceExclude":    "namespace-exclude",
+	"discovery.workloadKinds":       "workload-kinds",
+	"discovery.workloadResources":   "workload-resources",
+	"discovery.podVersions":         "pod-versions",
+	"discovery.appScope":            "app-scope",
+	"discovery.primaryVersion":      "primary-version",
+	"discovery.imageName":           "image-name",
+	"discovery.imageNamePattern":    "image-name-pattern",
+	"discovery.extractionRules":     "extraction-rules",
+}
+
+// reloadableFlags lists the settings that can change without a restart and
+// how to copy each one between configs
+var reloadableFlags = map[string]func(dst, src *types.Config){
+	"cache-ttl":          func(dst, src *types.Config) { dst.CacheTTL = src.CacheTTL },
+	"log-level":          func(dst, src *types.Config) { dst.LogLevel = src.LogLevel },
+	"fail-before-ready":  func(dst, src *types.Config) { dst.FailBeforeReady = src.FailBeforeReady },
+	"max-staleness":      func(dst, src *types.Config) { dst.MaxStaleness = src.MaxStaleness },
+	"embed-sources":      func(dst, src *types.Config) { dst.EmbedSources = src.EmbedSources },
+	"namespace-selector": func(dst, src *types.Config) { dst.NamespaceSelector = src.NamespaceSelector },
+	"namespace-include":  func(dst, src *types.Config) { dst.NamespaceInclude = src.NamespaceInclude },
+	"namespace-exclude":  func(dst, src *types.Config) { dst.NamespaceExclude = src.NamespaceExclude },
+	"workload-kinds":     func(dst, src *types.Config) { dst.WorkloadKinds = src.WorkloadKinds },
+	"app-scope":          func(dst, src *types.Config) { dst.AppScope = src.AppScope },
+	"primary-version":    func(dst, src *types.Config) { dst.PrimaryVersion = src.PrimaryVersion },
+	"image-name":         func(dst, src *types.Config) { dst.ImageName = src.ImageName },
+	"image-name-pattern": func(dst, src *types.Config) { dst.ImageNamePattern = src.ImageNamePattern },
+	"extraction-rules":   func(dst, src *types.Config) { dst.ExtractionRules = src.ExtractionRules },
+	"node-details":       func(dst, src *types.Config) { dst.NodeDetails = src.NodeDetails },
+	"node-role-rules":    func(dst, src *types.Config) { dst.NodeRoleRules = src.NodeRoleRules },
+	"node-ip-preference": func(dst, src *types.Config) { dst.NodeIPPreference = src.NodeIPPreference },
+	"cluster-name":       func(dst, src *types.Config) { dst.ClusterName = src.ClusterName },
+}
+
+// loadConfigFile reads a config file and returns its values keyed by flag name
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values, err := parseConfigFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseConfigFile parses YAML or JSON config data into values keyed by flag name
+func parseConfigFile(data []byte) (map[string]string, error) {
+	raw := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]string)
+	if err := flattenConfig("", raw, flat); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(flat))
+	var unknown []string
+	for key, value := range flat {
+		flag, ok := fileKeys[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		values[flag] = value
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// flattenConfig flattens nested sections into dotted keys with flag-style values
+func flattenConfig(prefix string, raw map[string]interface{}, out map[string]string) error {
+	for key, value := range raw {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			if err := flattenConfig(key, v, out); err != nil {
+				return err
+			}
+		case []interface{}:
+			// Lists of objects, such as extraction rules, are passed as JSON
+			if hasObjects(v) {
+				data, err := json.Marshal(v)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				out[key] = string(data)
+				continue
+			}
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				s, err := configScalar(key, item)
+				if err != nil {
+					return err
+				}
+				items = append(items, s)
+			}
+			out[key] = strings.Join(items, ",")
+		default:
+			s, err := configScalar(key, v)
+			if err != nil {
+				return err
+			}
+			out[key] = s
+		}
+	}
+	return nil
+}
+
+// hasObjects reports whether a config list contains nested objects or lists
+func hasObjects(items []interface{}) bool {
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// configScalar formats a scalar config value the way it would be passed as a flag
+func configScalar(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("%s: unsupported value %v", key, value)
+	}
+}
+
+// configWatcher reloads the config file when it changes and applies the safe
+// settings to the running services
+type configWatcher struct {
+	path      string
+	data      []byte
+	values    map[string]string
+	sources   map[string]string
+	current   *types.Config
+	logger    *logrus.Logger
+	discovery discoveryService
+}
+
+// newConfigWatcher creates a watcher for the config file that was loaded at startup
+func newConfigWatcher(path string, values, sources map[string]string, cfg *types.Config, logger *logrus.Logger, disc discoveryService) *configWatcher {
+	data, _ := os.ReadFile(path)
+	current := *cfg
+	return &configWatcher{
+		path:      path,
+		data:      data,
+		values:    values,
+		sources:   sources,
+		current:   &current,
+		logger:    logger,
+		discovery: disc,
+	}
+}
+
+// Run polls the config file until the context is cancelled
+func (w *configWatcher) Run(ctx context.Context) {
+	w.logger.WithField("path", w.path).Info("Watching config file for changes")
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(w.path)
+			if err != nil {
+				w.logger.WithError(err).WithField("path", w.path).Warn("Failed to read config file")
+				continue
+			}
+			if bytes.Equal(data, w.data) {
+				continue
+			}
+			w.data = data
+			w.reload(data)
+		}
+	}
+}
+
+// reload applies the changed, reloadable settings from new config file contents
+func (w *configWatcher) reload(data []byte) {
+	logger := w.logger.WithField("path", w.path)
+
+	values, err := parseConfigFile(data)
+	if err != nil {
+		logger.WithError(err).Error("Invalid config file, keeping current configuration")
+		return
+	}
+
+	// Parse the new file on top of the defaults and the chart's variables,
+	// which a setting removed from the file falls back to, and the old one
+	// for the diff
+	chartValues := chartEnvValues()
+	previous, err := configFromValues(chartValues, w.values)
+	if err != nil {
+		logger.WithError(err).Error("Failed to parse previous config file values")
+		return
+	}
+	updated, err := configFromValues(chartValues, values)
+	if err != nil {
+		logger.WithError(err).Error("Invalid config file, keeping current configuration")
+		return
+	}
+
+	next := *w.current
+	changes := logrus.Fields{}
+	for _, flag := range changedFlags(w.values, values) {
+		if previous.flags.Lookup(flag).Value.String() == updated.flags.Lookup(flag).Value.String() {
+			continue
+		}
+		if source := w.sources[flag]; source == "flag" || strings.HasPrefix(source, "env:") {
+			logger.WithFields(logrus.Fields{
+				"setting": flag,
+				"source":  source,
+			}).Info("Config file change ignored, setting is overridden")
+			continue
+		}
+
+		apply, ok := reloadableFlags[flag]
+		if !ok {
+			logger.WithField("setting", flag).Warn("Config file change requires a restart to take effect")
+			continue
+		}
+
+		apply(&next, updated.config)
+		changes[flag] = fmt.Sprintf("%s -> %s", previous.flags.Lookup(flag).Value, updated.flags.Lookup(flag).Value)
+	}
+
+	w.values = values
+	for flag, source := range w.sources {
+		if source == "flag" || strings.HasPrefix(source, "env:") {
+			continue
+		}
+		if _, ok := values[flag]; ok {
+			w.sources[flag] = "file"
+		} else if _, ok := chartValues[flag]; ok {
+			w.sources[flag] = "chart:" + legacyEnvNames[flag]
+		} else {
+			w.sources[flag] = "default"
+		}
+	}
+
+	if len(changes) == 0 {
+		logger.Info("Config file changed, nothing to reload")
+		return
+	}
+
+	if err := validateServerConfig(&next); err != nil {
+		logger.WithError(err).Error("Invalid config file, keeping current configuration")
+		return
+	}
+
+	logger.WithFields(changes).Info("Reloading configuration")
+
+	if _, ok := changes["log-level"]; ok {
+		setLogLevel(w.logger, next.LogLevel)
+	}
+
+	w.current = &next
+	reconfigured := next
+	w.discovery.Reconfigure(&reconfigured)
+}
+
+// parsedConfig is a config built from values on top of the flag defaults
+type parsedConfig struct {
+	config *types.Config
+	flags  *pflag.FlagSet
+}
+
+// configFromValues builds a config from flag defaults and sets of values,
+// each set overriding the ones before it. Every flag is set once, as list
+// flags append on later sets.
+func configFromValues(layers ...map[string]string) (*parsedConfig, error) {
+	cfg := &types.Config{}
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	addServerFlags(fs, cfg)
+
+	merged := make(map[string]string)
+	for _, values := range layers {
+		for flag, value := range values {
+			merged[flag] = value
+		}
+	}
+	for flag, value := range merged {
+		if err := fs.Set(flag, value); err != nil {
+			return nil, fmt.Errorf("%s=%q: %w", flag, value, err)
+		}
+	}
+	return &parsedConfig{config: cfg, flags: fs}, nil
+}
+
+// changedFlags returns the flags whose file values differ between two loads
+func changedFlags(before, after map[string]string) []string {
+	var changed []string
+	for flag, value := range after {
+		if old, ok := before[flag]; !ok || old != value {
+			changed = append(changed, flag)
+		}
+	}
+	for flag := range before {
+		if _, ok := after[flag]; !ok {
+			changed = append(changed, flag)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/yourorg/cluster-reflector/app/pkg/discovery"
 	"github.com/yourorg/cluster-reflector/app/pkg/server"
 	"github.com/yourorg/cluster-reflector/app/pkg/types"
@@ -65,27 +66,87 @@ var versionCmd = &cobra.Command{
 
 var config = &types.Config{}
 
+// configFile is the optional path passed with --config
+var configFile string
+
 func init() {
 	// Add subcommands
 	rootCmd.AddCommand(healthcheckCmd)
 	rootCmd.AddCommand(versionCmd)
 
 	// Server flags
-	rootCmd.Flags().StringVar(&config.Listen, "listen", ":8080", "Address to listen on")
-	rootCmd.Flags().DurationVar(&config.CacheTTL, "cache-ttl", 10*time.Second, "Cache TTL for cluster data")
-	rootCmd.Flags().StringVar(&config.NamespaceSelector, "namespace-selector", "", "Namespace selector for app discovery (empty = all namespaces)")
-	rootCmd.Flags().BoolVar(&config.PreferCRD, "prefer-crd", true, "Prefer AppVersion CRDs over workload discovery")
-	rootCmd.Flags().BoolVar(&config.FallbackWorkloads, "fallback-workloads", true, "Enable workload fallback discovery")
-	rootCmd.Flags().BoolVar(&config.CRDOnly, "crd-only", false, "Only discover from AppVersion CRDs, ignore workload discovery")
-	rootCmd.Flags().StringVar(&config.LogLevel, "log-level", "info", "Log level (debug, info, warn, error)")
-	rootCmd.Flags().StringSliceVar(&config.WorkloadKinds, "workload-kinds", []string{"Deployment", "StatefulSet"}, "Workload kinds to discover")
-	rootCmd.Flags().BoolVar(&config.MetricsEnabled, "metrics", false, "Enable Prometheus metrics endpoint")
+	addServerFlags(rootCmd.Flags(), config)
+	rootCmd.Flags().StringVar(&configFile, "config", "", "Path to a YAML or JSON config file, reloaded when it changes")
 
 	// Healthcheck flags
 	healthcheckCmd.Flags().StringVar(&config.Listen, "listen", ":8080", "Address to check")
 }
 
+// addServerFlags registers the flags that map onto types.Config
+func addServerFlags(fs *pflag.FlagSet, cfg *types.Config) {
+	fs.StringVar(&cfg.Listen, "listen", ":8080", "Address to listen on")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", 10*time.Second, "Cache TTL for cluster data")
+	fs.StringVar(&cfg.NamespaceSelector, "namespace-selector", "", "Namespace label selector for app discovery (empty = all namespaces); name namespaces with --namespace-include")
+	fs.StringSliceVar(&cfg.NamespaceInclude, "namespace-include", nil, "Namespaces always included in app discovery")
+	fs.StringSliceVar(&cfg.NamespaceExclude, "namespace-exclude", nil, "Namespaces always excluded from app discovery")
+	fs.BoolVar(&cfg.AppDiscoveryEnabled, "app-discovery", true, "Enable application discovery (AppVersion CRDs and workloads)")
+	fs.BoolVar(&cfg.PreferCRD, "prefer-crd", true, "Prefer AppVersion CRDs over workload discovery")
+	fs.BoolVar(&cfg.FallbackWorkloads, "fallback-workloads", true, "Enable workload fallback discovery")
+	fs.BoolVar(&cfg.CRDOnly, "crd-only", false, "Only discover from AppVersion CRDs, ignore workload discovery")
+	fs.BoolVar(&cfg.AppVersionStatus, "appversion-status", false, "Write observedAt, matched workloads and Verified/Drift conditions to AppVersion status")
+	fs.BoolVar(&cfg.GenerateAppVersions, "generate-appversions", false, "Create, update and delete AppVersions mirroring discovered workloads")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	fs.StringSliceVar(&cfg.WorkloadKinds, "workload-kinds", []string{"Deployment", "StatefulSet"}, "Workload kinds to discover (Deployment, StatefulSet, DaemonSet, ReplicaSet, Job, CronJob, Rollout or a kind from --workload-resources)")
+	fs.StringSliceVar(&cfg.WorkloadResources, "workload-resources", nil, "Custom workload kinds as Kind=group/version/resource[:template.path]")
+	fs.StringVar(&cfg.AppScope, "app-scope", "cluster", "Group apps by name across the cluster (cluster) or by namespace and name (namespace)")
+	fs.StringVar(&cfg.PrimaryVersion, "primary-version", "crd", "Rule for an app's reported version: highest, replicas (most running pods) or crd (AppVersion-declared, else highest)")
+	fs.StringVar(&cfg.ImageName, "image-name", "last", "Which part of an image reference names an app found by its image: last (path segment), repository or regex")
+	fs.StringVar(&cfg.ImageNamePattern, "image-name-pattern", "", "Regex matched against registry/repository for --image-name=regex, the group named \"name\" or else the first group is the app name")
+	fs.Var(&rulesValue[types.ExtractionRule]{name: "extraction rules", rules: &cfg.ExtractionRules}, "extraction-rules", "App name and version extraction rules as a JSON list, tried in order before the recommended labels and image")
+	fs.BoolVar(&cfg.PodVersions, "pod-versions", false, "Report app versions from running pods, with replica counts per version")
+	fs.BoolVar(&cfg.FailBeforeReady, "fail-before-ready", true, "Return 503 from /cluster-info until the first cache refresh succeeds, instead of an empty snapshot")
+	fs.DurationVar(&cfg.MaxStaleness, "max-staleness", 0, "Return 503 from /cluster-info once the snapshot is older than this (0 serves stale data indefinitely)")
+	fs.BoolVar(&cfg.EmbedSources, "embed-sources", false, "Include the status of each discovery source in /cluster-info responses")
+	fs.IntVar(&cfg.HistorySize, "history-size", 1000, "Version transitions kept for /history (0 disables the history)")
+	fs.StringVar(&cfg.HistoryStore, "history-store", "memory", "Where the version history is persisted: memory, file or configmap")
+	fs.StringVar(&cfg.HistoryFile, "history-file", "", "File the version history is persisted to with --history-store=file")
+	fs.StringVar(&cfg.HistoryConfigMap, "history-configmap", "", "ConfigMap the version history is persisted to with --history-store=configmap, as [namespace/]name")
+	fs.BoolVar(&cfg.NodeDetails, "node-details", false, "Report conditions, capacity, allocatable, OS, runtime, zone, external IP and taints of nodes")
+	fs.Var(&rulesValue[types.NodeRoleRule]{name: "node role rules", rules: &cfg.NodeRoleRules}, "node-role-rules", "Rules assigning roles to nodes by label or taint as a JSON list, tried in order before the control-plane defaults")
+	fs.StringSliceVar(&cfg.NodeIPPreference, "node-ip-preference", discovery.DefaultNodeIPPreference, "Address types for a node's ip in order of preference, as Type or Type/Family (e.g. InternalIP/IPv6)")
+	fs.StringVar(&cfg.ClusterName, "cluster-name", "", "Name reported in the cluster block of /cluster-info")
+	fs.StringSliceVar(&cfg.APIGroups, "api-groups", discovery.DefaultAPIGroups, "API groups reported in the cluster block of /cluster-info when installed")
+	fs.StringSliceVar(&cfg.Clusters, "clusters", nil, "Run as a hub aggregating these clusters, each as name=kubeconfig-context or name=URL of its reflector")
+	fs.BoolVar(&cfg.MetricsEnabled, "metrics", false, "Enable Prometheus metrics endpoint")
+}
+
+// discoveryService is the discovery of a single cluster, or a hub of several
+type discoveryService interface {
+	Start(ctx context.Context) error
+	Stop()
+	Reconfigure(cfg *types.Config)
+}
+
 func runServer(cmd *cobra.Command, args []string) error {
+	// Load the config file, then resolve flags from the environment and the
+	// file before anything reads them
+	var fileValues map[string]string
+	if configFile != "" {
+		values, err := loadConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		fileValues = values
+	}
+
+	sources, err := resolveConfig(cmd, fileValues)
+	if err != nil {
+		return err
+	}
+	if err := validateServerConfig(config); err != nil {
+		return err
+	}
+
 	// Setup logging
 	logger := setupLogging(config.LogLevel)
 	
@@ -94,16 +155,26 @@ func runServer(cmd *cobra.Command, args []string) error {
 		"git_commit": GitCommit,
 		"build_date": BuildDate,
 	}).Info("Starting cluster-reflector")
-
-	// Create discovery service
-	disc, err := discovery.NewClusterDiscovery(config, logger)
-	if err != nil {
-		return fmt.Errorf("failed to create discovery service: %w", err)
+	logConfigSources(logger, sources)
+
+	// Create discovery service and HTTP server, aggregating the member
+	// clusters in hub mode
+	var disc discoveryService
+	var srv *server.Server
+	if len(config.Clusters) > 0 {
+		hub, err := discovery.NewHub(config, logger)
+		if err != nil {
+			return fmt.Errorf("failed to create hub: %w", err)
+		}
+		disc, srv = hub, server.NewHubServer(config, hub, logger)
+	} else {
+		cd, err := discovery.NewClusterDiscovery(config, logger)
+		if err != nil {
+			return fmt.Errorf("failed to create discovery service: %w", err)
+		}
+		disc, srv = cd, server.NewServer(config, cd, logger)
 	}
 
-	// Create HTTP server
-	srv := server.NewServer(config, disc, logger)
-
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -120,6 +191,12 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
+	// Reload safe settings when the config file changes
+	if configFile != "" {
+		watcher := newConfigWatcher(configFile, fileValues, sources, config, logger, disc)
+		go watcher.Run(ctx)
+	}
+
 	// Start HTTP server
 	go func() {
 		if err := srv.Start(ctx); err != nil {
@@ -148,6 +225,10 @@ func runServer(cmd *cobra.Command, args []string) error {
 }
 
 func runHealthcheck(cmd *cobra.Command, args []string) error {
+	if _, err := resolveConfig(cmd, nil); err != nil {
+		return err
+	}
+
 	// Parse listen address to get host and port
 	addr := config.Listen
 	if strings.HasPrefix(addr, ":") {
@@ -181,6 +262,23 @@ func setupLogging(level string) *logrus.Logger {
 	logger := logrus.New()
 
 	// Set log level
+	setLogLevel(logger, level)
+
+	// Set JSON formatter for structured logging
+	logger.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: time.RFC3339,
+		FieldMap: logrus.FieldMap{
+			logrus.FieldKeyTime:  "timestamp",
+			logrus.FieldKeyLevel: "level",
+			logrus.FieldKeyMsg:   "message",
+		},
+	})
+
+	return logger
+}
+
+// setLogLevel sets the logger level from a --log-level value
+func setLogLevel(logger *logrus.Logger, level string) {
 	switch strings.ToLower(level) {
 	case "debug":
 		logger.SetLevel(logrus.DebugLevel)
@@ -194,16 +292,4 @@ func setupLogging(level string) *logrus.Logger {
 		logger.SetLevel(logrus.InfoLevel)
 		logger.WithField("level", level).Warn("Unknown log level, using info")
 	}
-
-	// Set JSON formatter for structured logging
-	logger.SetFormatter(&logrus.JSONFormatter{
-		TimestampFormat: time.RFC3339,
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "level",
-			logrus.FieldKeyMsg:   "message",
-		},
-	})
-
-	return logger
 }
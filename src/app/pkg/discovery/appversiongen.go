@@ -0,0 +1,223 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Label marking objects the reflector creates and may update or delete
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "cluster-reflector"
+)
+
+// generatedAppVersion is the AppVersion mirroring one discovered workload
+type generatedAppVersion struct {
+	namespace string
+	name      string
+	app       string
+	version   string
+	owner     metav1.OwnerReference
+}
+
+// isGeneratedAppVersion reports whether an AppVersion was created by the
+// reflector rather than authored by hand
+func isGeneratedAppVersion(obj map[string]interface{}) bool {
+	value, _, _ := unstructured.NestedString(obj, "metadata", "labels", managedByLabel)
+	return value == managedByValue
+}
+
+// syncGeneratedAppVersions creates and updates an AppVersion for every
+// discovered workload, and deletes generated ones whose workload is gone.
+// Nothing is deleted after a rebuild in which a source failed, as its
+// workloads would be missing.
+func (cd *ClusterDiscovery) syncGeneratedAppVersions(ctx context.Context, apps []types.App, complete bool) {
+	if !cd.config.GenerateAppVersions || cd.appVersionLister == nil || cd.generateDenied.Load() {
+		return
+	}
+
+	desired := cd.desiredAppVersions(apps)
+	for _, gen := range desired {
+		if err := cd.applyGeneratedAppVersion(ctx, gen); err != nil {
+			if cd.generationDenied(err) {
+				return
+			}
+			cd.logger.WithError(err).WithFields(logrus.Fields{
+				"namespace": gen.namespace,
+				"name":      gen.name,
+			}).Warn("Failed to write generated AppVersion")
+		}
+	}
+
+	if !complete {
+		cd.logger.Debug("Not deleting generated AppVersions after an incomplete refresh")
+		return
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{managedByLabel: managedByValue})
+	existing, err := cd.appVersionLister.List(selector)
+	if err != nil {
+		cd.logger.WithError(err).Warn("Failed to list generated AppVersions")
+		return
+	}
+	for _, item := range unstructuredItems(existing) {
+		key := item.GetNamespace() + "/" + item.GetName()
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		err := cd.dynamicClient.Resource(appVersionGVR).Namespace(item.GetNamespace()).
+			Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			if cd.generationDenied(err) {
+				return
+			}
+			cd.logger.WithError(err).WithField("name", key).Warn("Failed to delete generated AppVersion")
+			continue
+		}
+		cd.logger.WithField("name", key).Info("Deleted generated AppVersion")
+	}
+}
+
+// generationDenied disables generation until restart if the API server
+// refused a write, reporting whether it did
+func (cd *ClusterDiscovery) generationDenied(err error) bool {
+	if !apierrors.IsForbidden(err) {
+		return false
+	}
+	cd.generateDenied.Store(true)
+	cd.logger.WithError(err).Warn("Not allowed to write AppVersions, generation disabled until restart")
+	return true
+}
+
+// desiredAppVersions returns the AppVersions to generate, keyed by
+// namespace/name. A workload reporting several versions, such as during a
+// rollout, is given the highest.
+func (cd *ClusterDiscovery) desiredAppVersions(apps []types.App) map[string]generatedAppVersion {
+	desired := make(map[string]generatedAppVersion)
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			if instance.Source == types.AppSourceAppVersion {
+				continue
+			}
+			name := strings.ToLower(instance.Kind) + "-" + instance.Name
+			key := instance.Namespace + "/" + name
+			if gen, ok := desired[key]; ok {
+				if compareVersions(instance.Version, gen.version) > 0 {
+					gen.version = instance.Version
+					desired[key] = gen
+				}
+				continue
+			}
+
+			owner, ok := cd.workloadOwnerReference(instance)
+			if !ok {
+				continue
+			}
+			desired[key] = generatedAppVersion{
+				namespace: instance.Namespace,
+				name:      name,
+				app:       app.Name,
+				version:   instance.Version,
+				owner:     owner,
+			}
+		}
+	}
+	return desired
+}
+
+// workloadOwnerReference returns a reference to the workload an app instance
+// was found on, from the workload informer's cache
+func (cd *ClusterDiscovery) workloadOwnerReference(instance types.AppInstance) (metav1.OwnerReference, bool) {
+	informer, ok := cd.workloadInformers[instance.Kind]
+	if !ok {
+		return metav1.OwnerReference{}, false
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(instance.Namespace + "/" + instance.Name)
+	if err != nil || !exists {
+		return metav1.OwnerReference{}, false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return metav1.OwnerReference{}, false
+	}
+	return metav1.OwnerReference{
+		APIVersion: cd.workloadSources[instance.Kind].apiVersion,
+		Kind:       instance.Kind,
+		Name:       accessor.GetName(),
+		UID:        accessor.GetUID(),
+	}, true
+}
+
+// applyGeneratedAppVersion creates a generated AppVersion or updates it if
+// it differs. An AppVersion of the same name without the managed-by label
+// was authored by hand and is left alone.
+func (cd *ClusterDiscovery) applyGeneratedAppVersion(ctx context.Context, gen generatedAppVersion) error {
+	client := cd.dynamicClient.Resource(appVersionGVR).Namespace(gen.namespace)
+	spec := map[string]interface{}{"name": gen.app, "version": gen.version}
+	owners := []metav1.OwnerReference{gen.owner}
+
+	obj, err := cd.appVersionLister.ByNamespace(gen.namespace).Get(gen.name)
+	if apierrors.IsNotFound(err) {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		u.SetAPIVersion(appVersionGVR.GroupVersion().String())
+		u.SetKind("AppVersion")
+		u.SetNamespace(gen.namespace)
+		u.SetName(gen.name)
+		u.SetLabels(map[string]string{managedByLabel: managedByValue})
+		u.SetOwnerReferences(owners)
+		if _, err := client.Create(ctx, u, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		cd.logger.WithFields(logrus.Fields{
+			"namespace": gen.namespace,
+			"name":      gen.name,
+			"version":   gen.version,
+		}).Info("Created generated AppVersion")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected AppVersion object %T", obj)
+	}
+	if !isGeneratedAppVersion(current.Object) {
+		cd.logger.WithFields(logrus.Fields{
+			"namespace": gen.namespace,
+			"name":      gen.name,
+		}).Debug("Not overwriting hand-authored AppVersion")
+		return nil
+	}
+
+	currentSpec, _, _ := unstructured.NestedMap(current.Object, "spec")
+	if reflect.DeepEqual(currentSpec, spec) && reflect.DeepEqual(current.GetOwnerReferences(), owners) {
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	if err := unstructured.SetNestedMap(updated.Object, spec, "spec"); err != nil {
+		return err
+	}
+	updated.SetOwnerReferences(owners)
+	if _, err := client.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	cd.logger.WithFields(logrus.Fields{
+		"namespace": gen.namespace,
+		"name":      gen.name,
+		"version":   gen.version,
+	}).Info("Updated generated AppVersion")
+	return nil
+}
@@ -0,0 +1,233 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// appVersionStatusRefresh is how often observedAt is renewed while nothing
+// else in an AppVersion's status changes. Status writes are watched like any
+// other AppVersion change, so they must not happen on every rebuild.
+const appVersionStatusRefresh = 5 * time.Minute
+
+// maxDriftMessages bounds the workloads named in a Drift condition message
+const maxDriftMessages = 3
+
+// matchedWorkload is a workload discovered for the app an AppVersion declares
+type matchedWorkload struct {
+	namespace string
+	kind      string
+	name      string
+	versions  []string
+}
+
+// updateAppVersionStatuses writes the status of every AppVersion in the
+// snapshot from the workloads discovered for the same app in its namespace
+func (cd *ClusterDiscovery) updateAppVersionStatuses(ctx context.Context, apps []types.App) {
+	if !cd.config.AppVersionStatus || cd.appVersionLister == nil || cd.statusDenied.Load() {
+		return
+	}
+
+	now := time.Now()
+	for _, app := range apps {
+		workloads := appWorkloads(app)
+		for _, instance := range app.Instances {
+			if instance.Source != types.AppSourceAppVersion {
+				continue
+			}
+			// Cluster-scoped apps span namespaces the AppVersion does not describe
+			matched := namespaceWorkloads(workloads, instance.Namespace)
+			if err := cd.updateAppVersionStatus(ctx, instance, matched, now); err != nil {
+				if apierrors.IsForbidden(err) {
+					cd.statusDenied.Store(true)
+					cd.logger.WithError(err).Warn("Not allowed to update AppVersion status, status updates disabled until restart")
+					return
+				}
+				cd.logger.WithError(err).WithFields(logrus.Fields{
+					"namespace": instance.Namespace,
+					"name":      instance.Name,
+				}).Warn("Failed to update AppVersion status")
+			}
+		}
+	}
+}
+
+// updateAppVersionStatus patches one AppVersion's status if it changed or
+// its observedAt is due for renewal
+func (cd *ClusterDiscovery) updateAppVersionStatus(ctx context.Context, instance types.AppInstance, workloads []matchedWorkload, now time.Time) error {
+	obj, err := cd.appVersionLister.ByNamespace(instance.Namespace).Get(instance.Name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected AppVersion object %T", obj)
+	}
+
+	var current types.AppVersionStatus
+	if raw, found, _ := unstructured.NestedMap(u.Object, "status"); found {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &current); err != nil {
+			cd.logger.WithError(err).WithField("name", u.GetName()).Debug("Ignoring unreadable AppVersion status")
+			current = types.AppVersionStatus{}
+		}
+	}
+
+	desired := desiredAppVersionStatus(instance.Version, workloads, current, u.GetGeneration(), now)
+	if sameAppVersionStatus(current, desired) && current.ObservedAt != nil &&
+		now.Sub(current.ObservedAt.Time) < appVersionStatusRefresh {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"status": desired})
+	if err != nil {
+		return err
+	}
+	_, err = cd.dynamicClient.Resource(appVersionGVR).Namespace(instance.Namespace).
+		Patch(ctx, instance.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return err
+	}
+
+	cd.logger.WithFields(logrus.Fields{
+		"namespace":        instance.Namespace,
+		"name":             instance.Name,
+		"matchedWorkloads": desired.MatchedWorkloads,
+	}).Debug("Updated AppVersion status")
+	return nil
+}
+
+// desiredAppVersionStatus computes the status of an AppVersion declaring a
+// version, keeping the transition times of conditions that did not change
+func desiredAppVersionStatus(declared string, workloads []matchedWorkload, current types.AppVersionStatus, generation int64, now time.Time) types.AppVersionStatus {
+	status := types.AppVersionStatus{
+		ObservedAt:       &metav1.Time{Time: now},
+		MatchedWorkloads: len(workloads),
+		Conditions:       append([]metav1.Condition(nil), current.Conditions...),
+	}
+
+	verified := metav1.Condition{
+		Type:               types.AppVersionVerified,
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.Time{Time: now},
+	}
+	drift := verified
+	drift.Type = types.AppVersionDrift
+
+	if len(workloads) == 0 {
+		verified.Status, verified.Reason = metav1.ConditionUnknown, "NoWorkloads"
+		verified.Message = "No workloads were discovered for this app"
+		drift.Status, drift.Reason, drift.Message = verified.Status, verified.Reason, verified.Message
+	} else {
+		var running int
+		var drifted []string
+		for _, w := range workloads {
+			var others []string
+			for _, v := range w.versions {
+				if v == declared {
+					running++
+				} else {
+					others = append(others, v)
+				}
+			}
+			if len(others) > 0 {
+				drifted = append(drifted, fmt.Sprintf("%s %s/%s runs %s", w.kind, w.namespace, w.name, strings.Join(others, ", ")))
+			}
+		}
+
+		if running > 0 {
+			verified.Status, verified.Reason = metav1.ConditionTrue, "VersionRunning"
+			verified.Message = fmt.Sprintf("%d of %d workloads run version %s", running, len(workloads), declared)
+		} else {
+			verified.Status, verified.Reason = metav1.ConditionFalse, "VersionNotRunning"
+			verified.Message = fmt.Sprintf("No workload runs version %s", declared)
+		}
+
+		if len(drifted) > 0 {
+			drift.Status, drift.Reason = metav1.ConditionTrue, "VersionMismatch"
+			if len(drifted) > maxDriftMessages {
+				drifted = append(drifted[:maxDriftMessages], fmt.Sprintf("and %d more", len(drifted)-maxDriftMessages))
+			}
+			drift.Message = strings.Join(drifted, ", ")
+		} else {
+			drift.Status, drift.Reason = metav1.ConditionFalse, "VersionMatches"
+			drift.Message = fmt.Sprintf("All workloads run version %s", declared)
+		}
+	}
+
+	meta.SetStatusCondition(&status.Conditions, verified)
+	meta.SetStatusCondition(&status.Conditions, drift)
+	return status
+}
+
+// sameAppVersionStatus compares statuses, ignoring observedAt and the
+// condition transition times
+func sameAppVersionStatus(a, b types.AppVersionStatus) bool {
+	if a.MatchedWorkloads != b.MatchedWorkloads || len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for _, want := range b.Conditions {
+		got := meta.FindStatusCondition(a.Conditions, want.Type)
+		if got == nil || got.Status != want.Status || got.Reason != want.Reason ||
+			got.Message != want.Message || got.ObservedGeneration != want.ObservedGeneration {
+			return false
+		}
+	}
+	return true
+}
+
+// appWorkloads returns the workloads an app was discovered on, with the
+// versions each reports
+func appWorkloads(app types.App) []matchedWorkload {
+	byKey := make(map[string]*matchedWorkload)
+	var keys []string
+	for _, instance := range app.Instances {
+		if instance.Source == types.AppSourceAppVersion {
+			continue
+		}
+		key := instance.Namespace + "/" + instance.Kind + "/" + instance.Name
+		w, ok := byKey[key]
+		if !ok {
+			w = &matchedWorkload{namespace: instance.Namespace, kind: instance.Kind, name: instance.Name}
+			byKey[key] = w
+			keys = append(keys, key)
+		}
+		if !slices.Contains(w.versions, instance.Version) {
+			w.versions = append(w.versions, instance.Version)
+		}
+	}
+
+	sort.Strings(keys)
+	workloads := make([]matchedWorkload, 0, len(keys))
+	for _, key := range keys {
+		workloads = append(workloads, *byKey[key])
+	}
+	return workloads
+}
+
+// namespaceWorkloads returns the workloads in one namespace
+func namespaceWorkloads(workloads []matchedWorkload, namespace string) []matchedWorkload {
+	var matched []matchedWorkload
+	for _, w := range workloads {
+		if w.namespace == namespace {
+			matched = append(matched, w)
+		}
+	}
+	return matched
+}
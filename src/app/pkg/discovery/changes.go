@@ -0,0 +1,199 @@
+package discovery
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// maxChangeEvents bounds the change log. Watchers further behind start over
+// from a snapshot.
+const maxChangeEvents = 1000
+
+// changeLog keeps the most recent changes between snapshots. It is guarded
+// by the cache mutex, so a snapshot and the last event ID recorded with it
+// are always read together.
+type changeLog struct {
+	// epoch identifies this log, as event IDs restart with the process
+	epoch   string
+	events  []types.ChangeEvent
+	lastID  uint64
+	changed chan struct{} // closed and replaced when events are recorded
+}
+
+// newChangeLog starts an empty change log
+func newChangeLog() *changeLog {
+	return &changeLog{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		changed: make(chan struct{}),
+	}
+}
+
+// record assigns IDs to events, appends them and wakes up watchers
+func (l *changeLog) record(events []types.ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for i := range events {
+		l.lastID++
+		events[i].ID = l.lastID
+	}
+	l.events = append(l.events, events...)
+	if excess := len(l.events) - maxChangeEvents; excess > 0 {
+		l.events = append([]types.ChangeEvent(nil), l.events[excess:]...)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns the events after an ID, or false if some were dropped
+func (l *changeLog) since(id uint64) ([]types.ChangeEvent, bool) {
+	if id > l.lastID {
+		return nil, false
+	}
+	if id == l.lastID {
+		return nil, true
+	}
+	if len(l.events) == 0 || l.events[0].ID > id+1 {
+		return nil, false
+	}
+	start := int(id + 1 - l.events[0].ID)
+	return append([]types.ChangeEvent(nil), l.events[start:]...), true
+}
+
+// ChangeEpoch identifies the change log of this process. Event IDs are only
+// comparable within one epoch.
+func (cd *ClusterDiscovery) ChangeEpoch() string {
+	return cd.changes.epoch
+}
+
+// ChangesSince returns the change events recorded after an event ID, and a
+// channel closed when the next events are recorded. It returns false if the
+// events after the ID are no longer kept, and the watcher has to start over
+// from the snapshot returned by GetClusterInfo.
+func (cd *ClusterDiscovery) ChangesSince(id uint64) ([]types.ChangeEvent, <-chan struct{}, bool) {
+	cd.cacheMutex.RLock()
+	defer cd.cacheMutex.RUnlock()
+
+	events, ok := cd.changes.since(id)
+	return events, cd.changes.changed, ok
+}
+
+// diffSnapshots computes the changes from one snapshot to the next. The
+// first snapshot has no changes, watchers get it in full instead.
+func diffSnapshots(previous, next *types.ClusterInfo, at time.Time) []types.ChangeEvent {
+	if previous == nil {
+		return nil
+	}
+	var events []types.ChangeEvent
+
+	oldApps := make(map[string]types.App, len(previous.Apps))
+	for _, app := range previous.Apps {
+		oldApps[app.Namespace+"/"+app.Name] = app
+	}
+	for _, app := range next.Apps {
+		key := app.Namespace + "/" + app.Name
+		old, existed := oldApps[key]
+		delete(oldApps, key)
+		event := types.ChangeEvent{Time: at, App: app.Name, Namespace: app.Namespace}
+
+		if !existed {
+			event.Type = types.ChangeAppAdded
+			event.Version = app.Version
+			events = append(events, event)
+			continue
+		}
+		if app.Version != old.Version {
+			changed := event
+			changed.Type = types.ChangeAppVersionChanged
+			changed.Version = app.Version
+			changed.PreviousVersion = old.Version
+			events = append(events, changed)
+		}
+		for _, variant := range missingFrom(app.Variants, old.Variants) {
+			added := event
+			added.Type = types.ChangeVariantAdded
+			added.Variant = variant
+			events = append(events, added)
+		}
+		for _, variant := range missingFrom(old.Variants, app.Variants) {
+			removed := event
+			removed.Type = types.ChangeVariantRemoved
+			removed.Variant = variant
+			events = append(events, removed)
+		}
+	}
+	// Removed apps follow in the order of the previous snapshot
+	for _, app := range previous.Apps {
+		if _, removed := oldApps[app.Namespace+"/"+app.Name]; removed {
+			events = append(events, types.ChangeEvent{
+				Type:            types.ChangeAppRemoved,
+				Time:            at,
+				App:             app.Name,
+				Namespace:       app.Namespace,
+				PreviousVersion: app.Version,
+			})
+		}
+	}
+
+	oldNodes := make(map[string]types.Node, len(previous.Nodes))
+	for _, node := range previous.Nodes {
+		oldNodes[node.Name] = node
+	}
+	for _, node := range next.Nodes {
+		old, existed := oldNodes[node.Name]
+		delete(oldNodes, node.Name)
+		event := types.ChangeEvent{Time: at, Node: node.Name}
+
+		if !existed {
+			event.Type = types.ChangeNodeAdded
+			event.Role = node.Role
+			event.Version = node.Version
+			events = append(events, event)
+			continue
+		}
+		if node.Role != old.Role {
+			changed := event
+			changed.Type = types.ChangeNodeRoleChanged
+			changed.Role = node.Role
+			changed.PreviousRole = old.Role
+			events = append(events, changed)
+		}
+		if node.Version != old.Version {
+			changed := event
+			changed.Type = types.ChangeNodeVersionChanged
+			changed.Version = node.Version
+			changed.PreviousVersion = old.Version
+			events = append(events, changed)
+		}
+	}
+	for _, node := range previous.Nodes {
+		if _, removed := oldNodes[node.Name]; removed {
+			events = append(events, types.ChangeEvent{
+				Type:            types.ChangeNodeRemoved,
+				Time:            at,
+				Node:            node.Name,
+				PreviousRole:    node.Role,
+				PreviousVersion: node.Version,
+			})
+		}
+	}
+
+	return events
+}
+
+// missingFrom returns the values of a that are not in b, in the order of a
+func missingFrom(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, v := range b {
+		present[v] = true
+	}
+	var missing []string
+	for _, v := range a {
+		if !present[v] {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
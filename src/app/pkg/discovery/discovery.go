@@ -2,20 +2,34 @@ package discovery
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"regexp"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/metrics"
 	"github.com/yourorg/cluster-reflector/app/pkg/types"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
@@ -30,6 +44,60 @@ type ClusterDiscovery struct {
 	cache           *types.ClusterCache
 	cacheMutex      sync.RWMutex
 	stopCh          chan struct{}
+
+	// Shared informers backing discovery
+	informerFactory   informers.SharedInformerFactory
+	dynamicFactory    dynamicinformer.DynamicSharedInformerFactory
+	informersSynced   map[string]cache.InformerSynced
+	informersMutex    sync.RWMutex
+	synced            atomic.Bool
+	nodeLister        corelisters.NodeLister
+	namespaceLister   corelisters.NamespaceLister
+	appVersionLister  cache.GenericLister
+	workloadInformers map[string]cache.SharedIndexInformer
+	workloadSources   map[string]workloadSource
+	podInformer       cache.SharedIndexInformer
+
+	// Watch events mark parts of the snapshot dirty and signal changeCh
+	nodesDirty atomic.Bool
+	appsDirty  atomic.Bool
+	changeCh   chan struct{}
+
+	// Config updates from a reloaded config file, applied by the discovery loop
+	reconfigureCh chan *types.Config
+
+	// Namespaces app discovery is limited to
+	namespaceFilter *namespaceFilter
+
+	// Compiled pattern for the regex image name mode
+	imageNamePattern *regexp.Regexp
+
+	// Rules for finding app names and versions on workloads, in order
+	extractionRules []extractionRule
+
+	// Address types for a node's IP, in order of preference
+	nodeIPPreference []addressPreference
+
+	// Changes between successive snapshots, guarded by cacheMutex
+	changes *changeLog
+
+	// Version transitions, nil if the history is disabled
+	history *versionHistory
+
+	// Set once AppVersion status updates were refused by the API server
+	statusDenied atomic.Bool
+
+	// Set once writing generated AppVersions was refused by the API server
+	generateDenied atomic.Bool
+
+	// Set for the clusters of a hub, whose snapshots are not exported as
+	// metrics as they would overwrite each other
+	hubMember bool
+
+	// Identity of the cluster, owned by the discovery loop
+	identity          *types.ClusterIdentity
+	identityNextFetch time.Time
+	identityFailures  int
 }
 
 // NewClusterDiscovery creates a new ClusterDiscovery instance
@@ -38,13 +106,36 @@ func NewClusterDiscovery(cfg *types.Config, logger *logrus.Logger) (*ClusterDisc
 	if err := validateConfig(cfg); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
-	
+
 	// Get Kubernetes config
 	restConfig, err := config.GetConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get kubernetes config: %w", err)
 	}
 
+	return newClusterDiscovery(cfg, logger, restConfig)
+}
+
+// newClusterDiscovery creates a ClusterDiscovery for a validated
+// configuration, talking to the cluster of the given REST config
+func newClusterDiscovery(cfg *types.Config, logger *logrus.Logger, restConfig *rest.Config) (*ClusterDiscovery, error) {
+	namespaceFilter, err := parseNamespaceFilter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	imageNamePattern, err := parseImageNamePattern(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	extractionRules, err := parseExtractionRules(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	nodeIPPreference, err := parseNodeIPPreference(cfg.NodeIPPreference)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	// Create clientset
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
@@ -74,9 +165,20 @@ func NewClusterDiscovery(cfg *types.Config, logger *logrus.Logger) (*ClusterDisc
 		config:        cfg,
 		logger:        logger,
 		cache: &types.ClusterCache{
-			TTL: cfg.CacheTTL,
+			TTL:             cfg.CacheTTL,
+			FailBeforeReady: cfg.FailBeforeReady,
+			MaxStaleness:    cfg.MaxStaleness,
+			EmbedSources:    cfg.EmbedSources,
 		},
-		stopCh: make(chan struct{}),
+		stopCh:          make(chan struct{}),
+		changeCh:        make(chan struct{}, 1),
+		reconfigureCh:   make(chan *types.Config, 1),
+		namespaceFilter:  namespaceFilter,
+		imageNamePattern: imageNamePattern,
+		extractionRules:  extractionRules,
+		nodeIPPreference: nodeIPPreference,
+		changes:          newChangeLog(),
+		history:          newVersionHistory(cfg, clientset),
 	}, nil
 }
 
@@ -86,20 +188,52 @@ func (cd *ClusterDiscovery) Start(ctx context.Context) error {
 	
 	// Log discovery configuration
 	cd.logger.WithFields(logrus.Fields{
+		"appDiscovery":     cd.config.AppDiscoveryEnabled,
 		"preferCRD":        cd.config.PreferCRD,
 		"fallbackWorkloads": cd.config.FallbackWorkloads,
 		"crdOnly":          cd.config.CRDOnly,
 		"namespaceSelector": cd.config.NamespaceSelector,
+		"namespaceInclude": cd.config.NamespaceInclude,
+		"namespaceExclude": cd.config.NamespaceExclude,
 		"workloadKinds":    cd.config.WorkloadKinds,
 	}).Info("Discovery configuration")
 
-	// Initial refresh
+	// Informers run until discovery stops
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := cd.setupInformers(); err != nil {
+		return fmt.Errorf("failed to set up informers: %w", err)
+	}
+
+	cd.informerFactory.Start(ctx.Done())
+	cd.dynamicFactory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), cd.syncFuncs()...) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to sync informer caches: %v", cd.unsyncedInformers())
+	}
+	cd.synced.Store(true)
+	cd.logInformers()
+
+	// Restore the persisted history before the first refresh adds to it
+	if cd.history != nil && cd.history.store != nil {
+		if err := cd.history.load(ctx); err != nil {
+			cd.logger.WithError(err).Warn("Failed to load version history, starting empty")
+		}
+		go cd.runHistoryStore(ctx)
+	}
+
+	// Initial build from the synced caches
 	if err := cd.refreshCache(ctx); err != nil {
 		return fmt.Errorf("failed initial cache refresh: %w", err)
 	}
 
-	// Start periodic refresh
-	ticker := time.NewTicker(cd.config.CacheTTL / 2) // Refresh at half the TTL
+	// Rebuild on watch events, and periodically so the cache never expires
+	// while nothing in the cluster changes
+	ticker := time.NewTicker(cd.config.CacheTTL / 2)
 	defer ticker.Stop()
 
 	for {
@@ -110,6 +244,16 @@ func (cd *ClusterDiscovery) Start(ctx context.Context) error {
 		case <-cd.stopCh:
 			cd.logger.Info("Discovery stopped")
 			return nil
+		case <-cd.changeCh:
+			if err := cd.applyChanges(ctx); err != nil {
+				cd.logger.WithError(err).Error("Failed to apply cluster changes")
+			}
+		case cfg := <-cd.reconfigureCh:
+			if err := cd.applyConfig(ctx, cfg); err != nil {
+				cd.logger.WithError(err).Error("Failed to apply configuration update")
+				continue
+			}
+			ticker.Reset(cfg.CacheTTL / 2)
 		case <-ticker.C:
 			if err := cd.refreshCache(ctx); err != nil {
 				cd.logger.WithError(err).Error("Failed to refresh cache")
@@ -123,53 +267,343 @@ func (cd *ClusterDiscovery) Stop() {
 	close(cd.stopCh)
 }
 
-// GetClusterInfo returns cached cluster information
-func (cd *ClusterDiscovery) GetClusterInfo() *types.ClusterInfo {
+// Reconfigure hands an updated configuration to the running discovery loop.
+// Only the cache TTL, namespace selector and workload kinds take effect,
+// everything else requires a restart.
+func (cd *ClusterDiscovery) Reconfigure(cfg *types.Config) {
+	// A pending update that was not applied yet is superseded by this one
+	select {
+	case <-cd.reconfigureCh:
+	default:
+	}
+	cd.reconfigureCh <- cfg
+}
+
+// applyConfig switches the discovery loop to a new configuration
+func (cd *ClusterDiscovery) applyConfig(ctx context.Context, cfg *types.Config) error {
+	if err := validateConfig(cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	namespaceFilter, err := parseNamespaceFilter(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	imageNamePattern, err := parseImageNamePattern(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	extractionRules, err := parseExtractionRules(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	nodeIPPreference, err := parseNodeIPPreference(cfg.NodeIPPreference)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	cd.logger.WithFields(logrus.Fields{
+		"cacheTTL":          cfg.CacheTTL,
+		"namespaceSelector": cfg.NamespaceSelector,
+		"namespaceInclude":  cfg.NamespaceInclude,
+		"namespaceExclude":  cfg.NamespaceExclude,
+		"workloadKinds":     cfg.WorkloadKinds,
+		"appScope":          cfg.AppScope,
+		"primaryVersion":    cfg.PrimaryVersion,
+		"imageName":         cfg.ImageName,
+		"extractionRules":   len(cfg.ExtractionRules),
+	}).Info("Applying configuration update")
+
+	previous := cd.saveConfigState()
+	cd.config = cfg
+	cd.namespaceFilter = namespaceFilter
+	cd.imageNamePattern = imageNamePattern
+	cd.extractionRules = extractionRules
+	cd.nodeIPPreference = nodeIPPreference
+	cd.cacheMutex.Lock()
+	cd.cache.TTL = cfg.CacheTTL
+	cd.cache.FailBeforeReady = cfg.FailBeforeReady
+	cd.cache.MaxStaleness = cfg.MaxStaleness
+	cd.cache.EmbedSources = cfg.EmbedSources
+	cd.cacheMutex.Unlock()
+
+	// Newly enabled workload kinds and namespace filters need their
+	// informers started and synced
+	added, err := cd.setupWorkloadInformers()
+	if err != nil {
+		cd.restoreConfigState(previous)
+		return err
+	}
+	if cd.config.AppDiscoveryEnabled {
+		namespacesSynced, err := cd.setupNamespaceInformer()
+		if err != nil {
+			cd.restoreConfigState(previous)
+			return err
+		}
+		if namespacesSynced != nil {
+			added = append(added, namespacesSynced)
+		}
+	}
+	if len(added) > 0 {
+		cd.informerFactory.Start(ctx.Done())
+		cd.dynamicFactory.Start(ctx.Done())
+
+		// An informer the service account may not list never syncs, and
+		// waiting for it would block the discovery loop
+		syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+		synced := cache.WaitForCacheSync(syncCtx.Done(), added...)
+		cancel()
+		if !synced {
+			pending := cd.unsyncedInformers()
+			cd.restoreConfigState(previous)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("informers %v did not sync within %s, check that the service account may list and watch them; keeping the previous configuration",
+				pending, informerSyncTimeout)
+		}
+	}
+
+	return cd.refreshCache(ctx)
+}
+
+// configState is the part of the discovery state a configuration update
+// changes, kept to roll back an update whose informers fail to sync
+type configState struct {
+	config           *types.Config
+	namespaceFilter  *namespaceFilter
+	imageNamePattern *regexp.Regexp
+	extractionRules  []extractionRule
+	nodeIPPreference []addressPreference
+	workloadSources  map[string]workloadSource
+	workloadKinds    map[string]bool
+	informers        map[string]bool
+	namespaceLister  corelisters.NamespaceLister
+	podInformer      cache.SharedIndexInformer
+}
+
+// saveConfigState captures the state a configuration update changes
+func (cd *ClusterDiscovery) saveConfigState() *configState {
+	state := &configState{
+		config:           cd.config,
+		namespaceFilter:  cd.namespaceFilter,
+		imageNamePattern: cd.imageNamePattern,
+		extractionRules:  cd.extractionRules,
+		nodeIPPreference: cd.nodeIPPreference,
+		workloadSources:  cd.workloadSources,
+		workloadKinds:    make(map[string]bool, len(cd.workloadInformers)),
+		informers:        make(map[string]bool),
+		namespaceLister:  cd.namespaceLister,
+		podInformer:      cd.podInformer,
+	}
+	for kind := range cd.workloadInformers {
+		state.workloadKinds[kind] = true
+	}
+	cd.informersMutex.RLock()
+	for name := range cd.informersSynced {
+		state.informers[name] = true
+	}
+	cd.informersMutex.RUnlock()
+	return state
+}
+
+// restoreConfigState rolls back to a saved state. Informers added since are
+// dropped from discovery and health checks; the factories keep running them
+// in the background, and they are reused if their kinds are enabled again.
+func (cd *ClusterDiscovery) restoreConfigState(state *configState) {
+	cd.config = state.config
+	cd.namespaceFilter = state.namespaceFilter
+	cd.imageNamePattern = state.imageNamePattern
+	cd.extractionRules = state.extractionRules
+	cd.nodeIPPreference = state.nodeIPPreference
+	cd.workloadSources = state.workloadSources
+	cd.namespaceLister = state.namespaceLister
+	cd.podInformer = state.podInformer
+	for kind := range cd.workloadInformers {
+		if !state.workloadKinds[kind] {
+			delete(cd.workloadInformers, kind)
+		}
+	}
+
+	cd.informersMutex.Lock()
+	for name := range cd.informersSynced {
+		if !state.informers[name] {
+			delete(cd.informersSynced, name)
+		}
+	}
+	cd.informersMutex.Unlock()
+
+	cd.cacheMutex.Lock()
+	cd.cache.TTL = state.config.CacheTTL
+	cd.cache.FailBeforeReady = state.config.FailBeforeReady
+	cd.cache.MaxStaleness = state.config.MaxStaleness
+	cd.cache.EmbedSources = state.config.EmbedSources
+	cd.cacheMutex.Unlock()
+}
+
+// informerSyncTimeout bounds the wait for informers added by a configuration
+// update
+const informerSyncTimeout = 30 * time.Second
+
+// ErrNotReady is returned by GetClusterInfo before the first successful refresh
+var ErrNotReady = errors.New("cluster snapshot is not available yet")
+
+// ErrTooStale is returned by GetClusterInfo when the snapshot is older than
+// the configured maximum staleness
+var ErrTooStale = errors.New("cluster snapshot is too stale")
+
+// GetClusterInfo returns the last good cluster snapshot. A snapshot older than
+// the cache TTL is still returned, marked stale with the time of the last
+// refresh and the error that has kept it from being refreshed. An error is
+// returned, along with the staleness metadata, when the snapshot should not
+// be served.
+func (cd *ClusterDiscovery) GetClusterInfo() (*types.ClusterInfo, error) {
 	cd.cacheMutex.RLock()
 	defer cd.cacheMutex.RUnlock()
 
-	if cd.cache.Data == nil || cd.cache.IsExpired() {
-		cd.logger.Warn("Cache is expired or empty")
-		return &types.ClusterInfo{
+	if cd.cache.Data == nil {
+		info := &types.ClusterInfo{
 			APIVersion: "reflector.grid.sce.com/v1",
 			Timestamp:  time.Now(),
 			Nodes:      []types.Node{},
 			Apps:       []types.App{},
+			Stale:      true,
+			LastError:  cd.cache.LastError,
+		}
+		if cd.cache.EmbedSources {
+			info.Sources = cd.sortedSources()
+		}
+		if cd.cache.FailBeforeReady {
+			return info, ErrNotReady
 		}
+		cd.logger.Warn("Cache is empty")
+		return info, nil
 	}
 
 	// Update timestamp for current request
 	info := *cd.cache.Data
 	info.Timestamp = time.Now()
-	return &info
+	info.EventID = cd.changes.lastID
+	lastRefreshed := cd.cache.UpdatedAt
+	info.LastRefreshed = &lastRefreshed
+	info.ModifiedAt = cd.cache.ModifiedAt
+	if cd.cache.EmbedSources {
+		info.Sources = cd.sortedSources()
+	}
+
+	if cd.cache.IsExpired() {
+		info.Stale = true
+		info.LastError = cd.cache.LastError
+
+		if cd.cache.MaxStaleness > 0 && time.Since(cd.cache.UpdatedAt) > cd.cache.MaxStaleness {
+			return &info, ErrTooStale
+		}
+		cd.logger.WithField("lastRefreshed", lastRefreshed).Warn("Serving stale cache")
+	}
+
+	return &info, nil
 }
 
-// refreshCache updates the cache with current cluster information
+// refreshCache rebuilds the whole cache from the informer caches
 func (cd *ClusterDiscovery) refreshCache(ctx context.Context) error {
 	cd.logger.Debug("Refreshing cache")
 
+	cd.nodesDirty.Store(false)
+	cd.appsDirty.Store(false)
+	return cd.rebuildCache(ctx, true, true)
+}
+
+// applyChanges rebuilds the parts of the cache marked dirty by watch events
+func (cd *ClusterDiscovery) applyChanges(ctx context.Context) error {
+	nodes := cd.nodesDirty.Swap(false)
+	apps := cd.appsDirty.Swap(false)
+	if !nodes && !apps {
+		return nil
+	}
+
+	cd.logger.WithFields(logrus.Fields{
+		"nodes": nodes,
+		"apps":  apps,
+	}).Debug("Applying cluster changes")
+
+	return cd.rebuildCache(ctx, nodes, apps)
+}
+
+// rebuildCache updates the cache, reusing the previous snapshot for parts
+// that have not changed
+func (cd *ClusterDiscovery) rebuildCache(ctx context.Context, refreshNodes, refreshApps bool) error {
+	cd.cacheMutex.RLock()
+	previous := cd.cache.Data
+	cd.cacheMutex.RUnlock()
+
+	var nodes []types.Node
+	var apps []types.App
+	if previous != nil {
+		nodes = previous.Nodes
+		apps = previous.Apps
+	}
+
+	// Source statuses are stored even if the refresh fails, replacing only
+	// those of the parts that were discovered
+	rec := newSourceRecorder()
+	var nodesChecked, appsChecked bool
+	defer func() {
+		cd.storeSources(rec, nodesChecked, appsChecked)
+	}()
+
 	// Discover nodes
-	nodes, err := cd.discoverNodes(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to discover nodes: %w", err)
+	if refreshNodes || previous == nil {
+		nodesChecked = true
+		start := time.Now()
+		discovered, err := cd.discoverNodes(ctx)
+		observeDiscovery(metrics.SourceNodes, start, err)
+		rec.record(types.SourceKindNodes, "nodes", time.Since(start), len(discovered), err)
+		if err != nil {
+			return cd.refreshFailed(fmt.Errorf("failed to discover nodes: %w", err))
+		}
+		nodes = discovered
 	}
 
 	// Discover applications
-	apps, err := cd.discoverApps(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to discover apps: %w", err)
+	if refreshApps || previous == nil {
+		appsChecked = true
+		discovered, err := cd.discoverApps(ctx, rec)
+		if err != nil {
+			return cd.refreshFailed(fmt.Errorf("failed to discover apps: %w", err))
+		}
+		apps = discovered
 	}
 
+	cd.refreshIdentity(ctx)
+
 	// Update cache
-	cd.cacheMutex.Lock()
-	cd.cache.Data = &types.ClusterInfo{
+	info := &types.ClusterInfo{
 		APIVersion: "reflector.grid.sce.com/v1",
 		Timestamp:  time.Now(),
-		Nodes:      nodes,
-		Apps:       apps,
+		Nodes:       nodes,
+		Apps:        apps,
+		Cluster:     cd.identity,
+		ContentHash: contentHash(nodes, apps, cd.identity),
+	}
+	cd.cacheMutex.Lock()
+	cd.changes.record(diffSnapshots(cd.cache.Data, info, info.Timestamp))
+	if cd.history != nil {
+		cd.history.record(diffHistory(cd.cache.Data, info, info.Timestamp))
 	}
+	if cd.cache.Data == nil || cd.cache.Data.ContentHash != info.ContentHash {
+		cd.cache.ModifiedAt = info.Timestamp
+	}
+	cd.cache.Data = info
 	cd.cache.UpdatedAt = time.Now()
+	cd.cache.LastError = ""
 	cd.cacheMutex.Unlock()
+	if !cd.hubMember {
+		metrics.RecordSnapshot(info)
+	}
+
+	if appsChecked {
+		cd.updateAppVersionStatuses(ctx, apps)
+		cd.syncGeneratedAppVersions(ctx, apps, !rec.failed())
+	}
 
 	cd.logger.WithFields(logrus.Fields{
 		"nodes": len(nodes),
@@ -179,73 +613,107 @@ func (cd *ClusterDiscovery) refreshCache(ctx context.Context) error {
 	return nil
 }
 
-// discoverNodes discovers cluster nodes
-func (cd *ClusterDiscovery) discoverNodes(ctx context.Context) ([]types.Node, error) {
-	nodeList, err := cd.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list nodes: %w", err)
-	}
-
-	nodes := make([]types.Node, 0, len(nodeList.Items))
-	for _, node := range nodeList.Items {
-		nodeInfo := types.Node{
-			Name:    node.Name,
-			IP:      cd.getNodeInternalIP(&node),
-			Role:    cd.getNodeRole(&node),
-			Version: node.Status.NodeInfo.KubeletVersion,
+// contentHash hashes the nodes, apps and cluster identity of a snapshot.
+// Node labels are included as they decide which nodes a label selector returns.
+// Instance observation times are left out, as they advance on every rebuild
+// and would change the hash without the content changing.
+func contentHash(nodes []types.Node, apps []types.App, cluster *types.ClusterIdentity) string {
+	type hashedNode struct {
+		types.Node
+		Labels map[string]string `json:"labels"`
+	}
+	hashed := make([]hashedNode, len(nodes))
+	for i, node := range nodes {
+		hashed[i] = hashedNode{Node: node, Labels: node.Labels}
+	}
+	hashedApps := make([]types.App, len(apps))
+	for i, app := range apps {
+		app.Instances = slices.Clone(app.Instances)
+		for j := range app.Instances {
+			app.Instances[j].ObservedAt = time.Time{}
 		}
-		nodes = append(nodes, nodeInfo)
+		hashedApps[i] = app
 	}
 
-	return nodes, nil
+	h := sha256.New()
+	json.NewEncoder(h).Encode(struct {
+		Nodes   []hashedNode           `json:"nodes"`
+		Apps    []types.App            `json:"apps"`
+		Cluster *types.ClusterIdentity `json:"cluster"`
+	}{hashed, hashedApps, cluster})
+	return hex.EncodeToString(h.Sum(nil)[:16])
 }
 
-// getNodeInternalIP extracts the internal IP of a node
-func (cd *ClusterDiscovery) getNodeInternalIP(node *corev1.Node) string {
-	for _, addr := range node.Status.Addresses {
-		if addr.Type == corev1.NodeInternalIP {
-			return addr.Address
-		}
-	}
-	return ""
+// refreshFailed records the error that kept the cache from being refreshed
+func (cd *ClusterDiscovery) refreshFailed(err error) error {
+	cd.cacheMutex.Lock()
+	cd.cache.LastError = err.Error()
+	cd.cacheMutex.Unlock()
+	return err
 }
 
-// getNodeRole determines the role of a node
-func (cd *ClusterDiscovery) getNodeRole(node *corev1.Node) string {
-	// Check for control-plane labels
-	if _, exists := node.Labels["node-role.kubernetes.io/control-plane"]; exists {
-		return "control-plane"
+// observeDiscovery records how long a discovery source took and whether it failed
+func observeDiscovery(source string, start time.Time, err error) {
+	metrics.DiscoveryDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.RefreshErrors.WithLabelValues(source).Inc()
 	}
-	if _, exists := node.Labels["node-role.kubernetes.io/master"]; exists {
-		return "control-plane"
+}
+
+// discoverNodes discovers cluster nodes from the node informer cache
+func (cd *ClusterDiscovery) discoverNodes(ctx context.Context) ([]types.Node, error) {
+	nodeList, err := cd.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
 
-	// Check for control-plane taints
-	for _, taint := range node.Spec.Taints {
-		if strings.Contains(taint.Key, "control-plane") || strings.Contains(taint.Key, "master") {
-			if taint.Effect == corev1.TaintEffectNoSchedule {
-				return "control-plane"
-			}
+	nodes := make([]types.Node, 0, len(nodeList))
+	for _, node := range nodeList {
+		nodeInfo := types.Node{
+			Name:    node.Name,
+			Version: node.Status.NodeInfo.KubeletVersion,
+			Labels:  node.Labels,
 		}
+		nodeInfo.IP, nodeInfo.Addresses = nodeAddresses(node, cd.nodeIPPreference)
+		nodeInfo.Role, nodeInfo.Roles = nodeRoles(node, cd.config.NodeRoleRules)
+		if cd.config.NodeDetails {
+			nodeInfo.Details = nodeDetails(node)
+		}
+		nodes = append(nodes, nodeInfo)
 	}
 
-	return "worker"
+	// Listers return nodes in no particular order
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return nodes, nil
 }
 
 // discoverApps discovers applications in the cluster
-func (cd *ClusterDiscovery) discoverApps(ctx context.Context) ([]types.App, error) {
+func (cd *ClusterDiscovery) discoverApps(ctx context.Context, rec *sourceRecorder) ([]types.App, error) {
 	appMap := make(map[string]*types.App)
 
+	if !cd.config.AppDiscoveryEnabled {
+		return []types.App{}, nil
+	}
+
 	// Try CRD discovery first if enabled
 	if cd.config.PreferCRD {
-		if err := cd.discoverAppsFromCRD(ctx, appMap); err != nil {
+		start := time.Now()
+		err := cd.discoverAppsFromCRD(ctx, appMap, rec)
+		observeDiscovery(metrics.SourceCRD, start, err)
+		if err != nil {
 			cd.logger.WithError(err).Warn("CRD discovery failed, falling back to workloads")
 		}
 	}
 
 	// Fallback to workload discovery if enabled and not CRD-only mode
 	if cd.config.FallbackWorkloads && !cd.config.CRDOnly {
-		if err := cd.discoverAppsFromWorkloads(ctx, appMap); err != nil {
+		start := time.Now()
+		err := cd.discoverAppsFromWorkloads(ctx, appMap, rec)
+		observeDiscovery(metrics.SourceWorkloads, start, err)
+		if err != nil {
 			cd.logger.WithError(err).Error("Workload discovery failed")
 		}
 	} else if cd.config.CRDOnly {
@@ -257,43 +725,55 @@ func (cd *ClusterDiscovery) discoverApps(ctx context.Context) ([]types.App, erro
 	for _, app := range appMap {
 		apps = append(apps, *app)
 	}
+	cd.sortApps(apps)
 
 	return apps, nil
 }
 
-// discoverAppsFromCRD discovers apps from AppVersion CRDs
-func (cd *ClusterDiscovery) discoverAppsFromCRD(ctx context.Context, appMap map[string]*types.App) error {
-	// Define AppVersion GVR
-	gvr := schema.GroupVersionResource{
-		Group:    "cluster.grid.sce.com",
-		Version:  "v1alpha1",
-		Resource: "appversions",
+// discoverAppsFromCRD discovers apps from the AppVersion informer cache
+func (cd *ClusterDiscovery) discoverAppsFromCRD(ctx context.Context, appMap map[string]*types.App, rec *sourceRecorder) error {
+	// CRD not installed, already reported when the informers were set up
+	if cd.appVersionLister == nil {
+		rec.skip(types.SourceKindCRD, "appversions", "AppVersion CRD is not installed")
+		return nil
 	}
 
 	// List AppVersions
-	if cd.config.NamespaceSelector == "" {
+	namespaces := cd.resolveNamespaces()
+	if len(namespaces) == 1 && namespaces[0] == "" {
 		// List from all namespaces
-		list, err := cd.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+		start := time.Now()
+		list, err := cd.appVersionLister.List(labels.Everything())
 		if err != nil {
-			return fmt.Errorf("failed to list AppVersions: %w", err)
+			err = fmt.Errorf("failed to list AppVersions: %w", err)
+			rec.record(types.SourceKindCRD, "appversions", time.Since(start), 0, err)
+			return err
 		}
 		// Process the list items and add to appMap
-		for _, item := range list.Items {
+		items := unstructuredItems(list)
+		for _, item := range items {
 			cd.processAppVersionFromUnstructured(item.Object, appMap)
 		}
+		rec.record(types.SourceKindCRD, "appversions", time.Since(start), len(items), nil)
 	} else {
-		// Parse namespace selector and list from specific namespaces
-		namespaces := cd.parseNamespaceSelector(cd.config.NamespaceSelector)
+		// List from the namespaces matching the selector
 		for _, ns := range namespaces {
-			list, err := cd.dynamicClient.Resource(gvr).Namespace(ns).List(ctx, metav1.ListOptions{})
+			start := time.Now()
+			list, err := cd.appVersionLister.ByNamespace(ns).List(labels.Everything())
 			if err != nil {
+				err = fmt.Errorf("failed to list AppVersions in namespace %s: %w", ns, err)
+				rec.record(types.SourceKindCRD, "appversions", time.Since(start), 0, err)
+				rec.record(types.SourceKindNamespace, ns, time.Since(start), 0, err)
 				cd.logger.WithError(err).WithField("namespace", ns).Warn("Failed to list AppVersions in namespace")
 				continue
 			}
 			// Process the list items and add to appMap
-			for _, item := range list.Items {
+			items := unstructuredItems(list)
+			for _, item := range items {
 				cd.processAppVersionFromUnstructured(item.Object, appMap)
 			}
+			rec.record(types.SourceKindCRD, "appversions", time.Since(start), len(items), nil)
+			rec.record(types.SourceKindNamespace, ns, time.Since(start), len(items), nil)
 		}
 	}
 
@@ -301,140 +781,116 @@ func (cd *ClusterDiscovery) discoverAppsFromCRD(ctx context.Context, appMap map[
 }
 
 // discoverAppsFromWorkloads discovers apps from workload metadata
-func (cd *ClusterDiscovery) discoverAppsFromWorkloads(ctx context.Context, appMap map[string]*types.App) error {
-	namespaces := []string{""}
-	if cd.config.NamespaceSelector != "" {
-		namespaces = cd.parseNamespaceSelector(cd.config.NamespaceSelector)
+func (cd *ClusterDiscovery) discoverAppsFromWorkloads(ctx context.Context, appMap map[string]*types.App, rec *sourceRecorder) error {
+	namespaces := cd.resolveNamespaces()
+
+	var pods podIndex
+	if cd.config.PodVersions {
+		pods = cd.buildPodIndex(namespaces, rec)
 	}
 
 	for _, kind := range cd.config.WorkloadKinds {
-		switch kind {
-		case "Deployment":
-			if err := cd.discoverFromDeployments(ctx, namespaces, appMap); err != nil {
-				cd.logger.WithError(err).Error("Failed to discover from deployments")
-			}
-		case "StatefulSet":
-			if err := cd.discoverFromStatefulSets(ctx, namespaces, appMap); err != nil {
-				cd.logger.WithError(err).Error("Failed to discover from statefulsets")
-			}
+		if err := cd.discoverFromWorkloadKind(kind, namespaces, pods, appMap, rec); err != nil {
+			metrics.RefreshErrors.WithLabelValues(metrics.SourceWorkloads).Inc()
+			cd.logger.WithError(err).WithField("kind", kind).Error("Failed to discover from workloads")
 		}
 	}
 
 	return nil
 }
 
-// discoverFromDeployments discovers apps from deployments
-func (cd *ClusterDiscovery) discoverFromDeployments(ctx context.Context, namespaces []string, appMap map[string]*types.App) error {
-	for _, ns := range namespaces {
-		var deployments *appsv1.DeploymentList
-		var err error
-
-		if ns == "" {
-			// List from all namespaces
-			deployments, err = cd.clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
-		} else {
-			// List from specific namespace
-			deployments, err = cd.clientset.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
-		}
-
-		if err != nil {
-			return fmt.Errorf("failed to list deployments: %w", err)
-		}
-
-		for _, deployment := range deployments.Items {
-			cd.processWorkloadLabels(deployment.Labels, deployment.Spec.Template.Spec.Containers, appMap)
-		}
+// processWorkloadLabels processes workload labels to extract app information
+func (cd *ClusterDiscovery) processWorkloadLabels(kind string, workload metav1.Object, containers []corev1.Container, appMap map[string]*types.App) {
+	found, ok := cd.extractApp(kind, workload, containers)
+	if !ok {
+		return
 	}
 
-	return nil
+	image, digest := containerImage(found.container)
+	cd.addAppInstance(appMap, found.name, types.AppInstance{
+		Namespace:  workload.GetNamespace(),
+		Source:     found.source,
+		Rule:       found.rule.name,
+		Kind:       kind,
+		Name:       workload.GetName(),
+		Version:    found.version,
+		Image:      image,
+		Digest:     digest,
+		ObservedAt: time.Now(),
+	})
 }
 
-// discoverFromStatefulSets discovers apps from statefulsets
-func (cd *ClusterDiscovery) discoverFromStatefulSets(ctx context.Context, namespaces []string, appMap map[string]*types.App) error {
-	for _, ns := range namespaces {
-		var statefulSets *appsv1.StatefulSetList
-		var err error
+// appKey returns the key of an app in the app map, which includes the
+// namespace when apps are scoped per namespace
+func (cd *ClusterDiscovery) appKey(namespace, name string) string {
+	if cd.config.AppScope == types.AppScopeNamespace {
+		return namespace + "/" + name
+	}
+	return name
+}
 
-		if ns == "" {
-			statefulSets, err = cd.clientset.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
-		} else {
-			statefulSets, err = cd.clientset.AppsV1().StatefulSets(ns).List(ctx, metav1.ListOptions{})
+// addAppInstance records an instance of an app, creating the app if needed
+func (cd *ClusterDiscovery) addAppInstance(appMap map[string]*types.App, appName string, instance types.AppInstance) *types.App {
+	key := cd.appKey(instance.Namespace, appName)
+	existing, exists := appMap[key]
+	if !exists {
+		existing = &types.App{
+			Name:     appName,
+			Scope:    types.AppScopeCluster,
+			Version:  instance.Version,
+			Variants: []string{instance.Version},
 		}
-
-		if err != nil {
-			return fmt.Errorf("failed to list statefulsets: %w", err)
+		if cd.config.AppScope == types.AppScopeNamespace {
+			existing.Namespace = instance.Namespace
+			existing.Scope = types.AppScopeNamespace
 		}
-
-		for _, sts := range statefulSets.Items {
-			cd.processWorkloadLabels(sts.Labels, sts.Spec.Template.Spec.Containers, appMap)
+		appMap[key] = existing
+	} else {
+		// Add version to variants if not already present
+		found := false
+		for _, variant := range existing.Variants {
+			if variant == instance.Version {
+				found = true
+				break
+			}
 		}
-	}
-
-	return nil
-}
-
-// processWorkloadLabels processes workload labels to extract app information
-func (cd *ClusterDiscovery) processWorkloadLabels(labels map[string]string, containers []corev1.Container, appMap map[string]*types.App) {
-	appName := labels["app.kubernetes.io/name"]
-	appVersion := labels["app.kubernetes.io/version"]
-
-	// If no labels, try to parse from first container image
-	if appName == "" && len(containers) > 0 {
-		appName, appVersion = cd.parseImageTag(containers[0].Image)
-	}
-
-	if appName != "" {
-		if appVersion == "" {
-			appVersion = "unknown"
+		if !found {
+			existing.Variants = append(existing.Variants, instance.Version)
 		}
+	}
 
-		if existing, exists := appMap[appName]; exists {
-			// Add version to variants if not already present
-			found := false
-			for _, variant := range existing.Variants {
-				if variant == appVersion {
-					found = true
-					break
-				}
-			}
-			if !found {
-				existing.Variants = append(existing.Variants, appVersion)
-			}
-		} else {
-			appMap[appName] = &types.App{
-				Name:     appName,
-				Version:  appVersion,
-				Variants: []string{appVersion},
-			}
+	// Pods of one workload can report the same version many times
+	for i := range existing.Instances {
+		known := &existing.Instances[i]
+		if known.Source == instance.Source && known.Kind == instance.Kind &&
+			known.Namespace == instance.Namespace && known.Name == instance.Name &&
+			known.Version == instance.Version {
+			known.Replicas += instance.Replicas
+			known.Ready += instance.Ready
+			return existing
 		}
 	}
+	existing.Instances = append(existing.Instances, instance)
+	return existing
 }
 
-// parseImageTag extracts app name and version from container image tag
+// parseImageTag extracts app name and version from a container image reference
 func (cd *ClusterDiscovery) parseImageTag(image string) (string, string) {
-	// Remove registry prefix if present
-	parts := strings.Split(image, "/")
-	imageName := parts[len(parts)-1]
-
-	// Split name and tag
-	nameTag := strings.Split(imageName, ":")
-	if len(nameTag) < 2 {
-		return nameTag[0], "latest"
-	}
-
-	name := nameTag[0]
-	tag := nameTag[1]
-
-	// Remove @sha256: suffix if present
-	if strings.Contains(tag, "@") {
-		tag = strings.Split(tag, "@")[0]
+	ref, err := parseImageReference(image)
+	if err != nil {
+		cd.logger.WithError(err).Debug("Skipping unparseable image reference")
+		return "", ""
 	}
-
-	return name, tag
+	return cd.imageAppName(ref), ref.version()
 }
 
 // processAppVersionFromUnstructured processes an AppVersion from unstructured data
 func (cd *ClusterDiscovery) processAppVersionFromUnstructured(obj map[string]interface{}, appMap map[string]*types.App) {
+	// Generated AppVersions mirror workloads that are discovered themselves
+	if isGeneratedAppVersion(obj) {
+		return
+	}
+
 	spec, ok := obj["spec"].(map[string]interface{})
 	if !ok {
 		return
@@ -450,59 +906,43 @@ func (cd *ClusterDiscovery) processAppVersionFromUnstructured(obj map[string]int
 		return
 	}
 
-	if existing, exists := appMap[name]; exists {
-		// Add version to variants if not already present
-		found := false
-		for _, variant := range existing.Variants {
-			if variant == version {
-				found = true
-				break
-			}
-		}
-		if !found {
-			existing.Variants = append(existing.Variants, version)
+	observedAt := time.Now()
+	if observed, found, _ := unstructured.NestedString(obj, "status", "observedAt"); found {
+		if parsed, err := time.Parse(time.RFC3339, observed); err == nil {
+			observedAt = parsed
 		}
-		// Update main version to latest
-		existing.Version = version
-	} else {
-		appMap[name] = &types.App{
-			Name:     name,
-			Version:  version,
-			Variants: []string{version},
-		}
-	}
-}
-
-// parseNamespaceSelector parses namespace selector string
-func (cd *ClusterDiscovery) parseNamespaceSelector(selector string) []string {
-	if selector == "" {
-		return []string{""}
 	}
 
-	// Simple comma-separated namespace list for now
-	// TODO: Implement label selector parsing
-	namespaces := strings.Split(selector, ",")
-	for i, ns := range namespaces {
-		namespaces[i] = strings.TrimSpace(ns)
-	}
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	objName, _ := metadata["name"].(string)
 
-	return namespaces
+	cd.addAppInstance(appMap, name, types.AppInstance{
+		Namespace:  namespace,
+		Source:     types.AppSourceAppVersion,
+		Kind:       "AppVersion",
+		Name:       objName,
+		Version:    version,
+		ObservedAt: observedAt,
+	})
 }
 
-// HealthCheck performs a basic health check
+// HealthCheck reports healthy once every informer has synced and the cache is fresh
 func (cd *ClusterDiscovery) HealthCheck(ctx context.Context) error {
-	// Try to list nodes as a basic connectivity check
-	_, err := cd.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
-	if err != nil {
-		return fmt.Errorf("failed to connect to Kubernetes API: %w", err)
+	if !cd.synced.Load() {
+		return fmt.Errorf("informer caches not synced")
+	}
+	if pending := cd.unsyncedInformers(); len(pending) > 0 {
+		return fmt.Errorf("informer caches not synced: %v", pending)
 	}
 
 	// Check if cache is reasonably fresh
 	cd.cacheMutex.RLock()
 	cacheAge := time.Since(cd.cache.UpdatedAt)
+	ttl := cd.cache.TTL
 	cd.cacheMutex.RUnlock()
 
-	if cacheAge > cd.config.CacheTTL*2 {
+	if cacheAge > ttl*2 {
 		return fmt.Errorf("cache is stale (age: %s)", cacheAge)
 	}
 
@@ -518,6 +958,53 @@ func validateConfig(cfg *types.Config) error {
 	if cfg.CRDOnly && cfg.FallbackWorkloads {
 		logrus.Warn("CRD-only mode enabled but fallbackWorkloads is true - workloads will be ignored")
 	}
+
+	if cfg.GenerateAppVersions && (!cfg.PreferCRD || !cfg.FallbackWorkloads || cfg.CRDOnly) {
+		return fmt.Errorf("generating AppVersions requires preferCRD and fallbackWorkloads, and not CRD-only mode")
+	}
+
+	if _, err := parseNamespaceFilter(cfg); err != nil {
+		return err
+	}
+
+	if _, err := parseImageNamePattern(cfg); err != nil {
+		return err
+	}
+
+	if _, err := parseExtractionRules(cfg); err != nil {
+		return err
+	}
+
+	if err := validateNodeRoleRules(cfg.NodeRoleRules); err != nil {
+		return err
+	}
+
+	if _, err := parseNodeIPPreference(cfg.NodeIPPreference); err != nil {
+		return err
+	}
+
+	if err := validateHistoryConfig(cfg); err != nil {
+		return err
+	}
+
+	if err := validateHubConfig(cfg); err != nil {
+		return err
+	}
+
+	if err := validateWorkloadKinds(cfg); err != nil {
+		return err
+	}
+
+	if cfg.AppScope != types.AppScopeCluster && cfg.AppScope != types.AppScopeNamespace {
+		return fmt.Errorf("invalid app scope %q, must be %s or %s", cfg.AppScope, types.AppScopeCluster, types.AppScopeNamespace)
+	}
+
+	switch cfg.PrimaryVersion {
+	case types.PrimaryVersionHighest, types.PrimaryVersionReplicas, types.PrimaryVersionCRD:
+	default:
+		return fmt.Errorf("invalid primary version rule %q, must be %s, %s or %s", cfg.PrimaryVersion,
+			types.PrimaryVersionHighest, types.PrimaryVersionReplicas, types.PrimaryVersionCRD)
+	}
 	
 	return nil
 }
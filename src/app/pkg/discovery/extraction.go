@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultExtractionRules are evaluated after any configured rules and keep
+// the original behaviour: the recommended labels, else the first image
+var defaultExtractionRules = []types.ExtractionRule{
+	{
+		Name:    "default-labels",
+		AppName: []types.ValueSource{{Label: "app.kubernetes.io/name"}},
+		Version: []types.ValueSource{{Label: "app.kubernetes.io/version"}},
+	},
+	{
+		Name:    "default-image",
+		AppName: []types.ValueSource{{Image: true}},
+		Version: []types.ValueSource{{Image: true}},
+	},
+}
+
+// extractionRule is a parsed types.ExtractionRule
+type extractionRule struct {
+	name       string
+	kinds      map[string]bool
+	namespaces map[string]bool
+	container  string
+	appName    []valueSource
+	version    []valueSource
+}
+
+// valueSource is a parsed types.ValueSource
+type valueSource struct {
+	label      string
+	annotation string
+	image      bool
+	pattern    *regexp.Regexp
+}
+
+// extraction is the app an extraction rule found on an object
+type extraction struct {
+	rule      *extractionRule
+	name      string
+	version   string
+	source    string
+	container *corev1.Container
+}
+
+// parseExtractionRules parses the configured extraction rules and appends
+// the default rules
+func parseExtractionRules(cfg *types.Config) ([]extractionRule, error) {
+	configured := make([]types.ExtractionRule, 0, len(cfg.ExtractionRules)+len(defaultExtractionRules))
+	configured = append(configured, cfg.ExtractionRules...)
+	configured = append(configured, defaultExtractionRules...)
+
+	rules := make([]extractionRule, 0, len(configured))
+	for i, def := range configured {
+		rule := extractionRule{
+			name:       def.Name,
+			kinds:      make(map[string]bool),
+			namespaces: make(map[string]bool),
+			container:  def.Container,
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule-%d", i+1)
+		}
+		for _, kind := range def.Kinds {
+			rule.kinds[kind] = true
+		}
+		for _, ns := range def.Namespaces {
+			rule.namespaces[ns] = true
+		}
+		if _, err := path.Match(rule.container, ""); err != nil {
+			return nil, fmt.Errorf("extraction rule %s: invalid container pattern %q: %w", rule.name, rule.container, err)
+		}
+
+		if len(def.AppName) == 0 {
+			return nil, fmt.Errorf("extraction rule %s: no appName sources", rule.name)
+		}
+		var err error
+		if rule.appName, err = parseValueSources(rule.name, "appName", def.AppName); err != nil {
+			return nil, err
+		}
+		if rule.version, err = parseValueSources(rule.name, "version", def.Version); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// parseValueSources parses the value sources of one field of a rule
+func parseValueSources(rule, field string, defs []types.ValueSource) ([]valueSource, error) {
+	sources := make([]valueSource, 0, len(defs))
+	for i, def := range defs {
+		set := 0
+		for _, ok := range []bool{def.Label != "", def.Annotation != "", def.Image} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("extraction rule %s: %s source %d must set exactly one of label, annotation or image", rule, field, i+1)
+		}
+
+		source := valueSource{label: def.Label, annotation: def.Annotation, image: def.Image}
+		if def.Regex != "" {
+			pattern, err := regexp.Compile(def.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("extraction rule %s: %s source %d: invalid regex %q: %w", rule, field, i+1, def.Regex, err)
+			}
+			source.pattern = pattern
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// applies reports whether a rule is configured for a workload kind and namespace
+func (r *extractionRule) applies(kind, namespace string) bool {
+	if len(r.kinds) > 0 && !r.kinds[kind] {
+		return false
+	}
+	if len(r.namespaces) > 0 && !r.namespaces[namespace] {
+		return false
+	}
+	return true
+}
+
+// selectContainer returns the container a rule reads images from: the first
+// whose name matches the rule's pattern, or the first container
+func (r *extractionRule) selectContainer(containers []corev1.Container) *corev1.Container {
+	for i := range containers {
+		if r.container == "" {
+			return &containers[i]
+		}
+		if ok, _ := path.Match(r.container, containers[i].Name); ok {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// extractApp evaluates the extraction rules in order against a workload and
+// returns the app found by the first rule that yields a name
+func (cd *ClusterDiscovery) extractApp(kind string, workload metav1.Object, containers []corev1.Container) (extraction, bool) {
+	for i := range cd.extractionRules {
+		rule := &cd.extractionRules[i]
+		if !rule.applies(kind, workload.GetNamespace()) {
+			continue
+		}
+
+		container := rule.selectContainer(containers)
+		name, source := cd.extractValue(rule.appName, workload, container, false)
+		if name == "" {
+			continue
+		}
+		version, _ := cd.extractValue(rule.version, workload, container, true)
+		if version == "" {
+			version = "unknown"
+		}
+
+		return extraction{
+			rule:      rule,
+			name:      name,
+			version:   version,
+			source:    source,
+			container: container,
+		}, true
+	}
+
+	return extraction{}, false
+}
+
+// extractValue returns the first value found by a list of sources and the
+// app source it was read from. Image sources yield the image-derived name,
+// or the tag when version is set.
+func (cd *ClusterDiscovery) extractValue(sources []valueSource, obj metav1.Object, container *corev1.Container, version bool) (string, string) {
+	for _, source := range sources {
+		var value, from string
+		switch {
+		case source.label != "":
+			value, from = obj.GetLabels()[source.label], types.AppSourceLabels
+		case source.annotation != "":
+			value, from = obj.GetAnnotations()[source.annotation], types.AppSourceAnnotations
+		case source.image && container != nil:
+			name, tag := cd.parseImageTag(container.Image)
+			value, from = name, types.AppSourceImageTag
+			if version {
+				value = tag
+			}
+		}
+
+		if value != "" && source.pattern != nil {
+			value = transformValue(source.pattern, value)
+		}
+		if value != "" {
+			return value, from
+		}
+	}
+	return "", ""
+}
+
+// transformValue applies a regex to a value, keeping the group named "value",
+// else the first group, else the whole match. Values that do not match are
+// dropped so the next source is tried.
+func transformValue(pattern *regexp.Regexp, value string) string {
+	match := pattern.FindStringSubmatch(value)
+	if match == nil {
+		return ""
+	}
+	if i := pattern.SubexpIndex("value"); i > 0 {
+		return match[i]
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
@@ -0,0 +1,452 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// historyConfigMapKey is the ConfigMap key the history is stored under
+const historyConfigMapKey = "history.json"
+
+// versionHistory keeps the most recent version transitions in time order
+type versionHistory struct {
+	mu      sync.RWMutex
+	size    int
+	entries []types.HistoryEntry
+	store   historyStore
+	saveCh  chan struct{}
+}
+
+// historyStore persists the history so it survives restarts
+type historyStore interface {
+	Load(ctx context.Context) ([]types.HistoryEntry, error)
+	Save(ctx context.Context, entries []types.HistoryEntry) error
+	String() string
+}
+
+// HistoryQuery filters the version history. Empty fields match everything.
+type HistoryQuery struct {
+	Apps       map[string]bool
+	Nodes      map[string]bool
+	Namespaces map[string]bool
+	Since      time.Time
+	Until      time.Time
+	// Limit keeps the most recent matching entries
+	Limit int
+}
+
+// newVersionHistory sets up the history configured by cfg, or returns nil
+// if it is disabled
+func newVersionHistory(cfg *types.Config, clientset kubernetes.Interface) *versionHistory {
+	if cfg.HistorySize == 0 {
+		return nil
+	}
+
+	h := &versionHistory{
+		size:   cfg.HistorySize,
+		saveCh: make(chan struct{}, 1),
+	}
+	switch cfg.HistoryStore {
+	case types.HistoryStoreMemory:
+	case types.HistoryStoreFile:
+		h.store = &fileHistoryStore{path: cfg.HistoryFile}
+	case types.HistoryStoreConfigMap:
+		namespace, name := parseHistoryConfigMap(cfg.HistoryConfigMap)
+		h.store = &configMapHistoryStore{clientset: clientset, namespace: namespace, name: name}
+	}
+	return h
+}
+
+// validateHistoryConfig rejects history settings that cannot be used
+func validateHistoryConfig(cfg *types.Config) error {
+	if cfg.HistorySize < 0 {
+		return fmt.Errorf("invalid history size %d, must not be negative", cfg.HistorySize)
+	}
+	switch cfg.HistoryStore {
+	case types.HistoryStoreMemory:
+	case types.HistoryStoreFile:
+		if cfg.HistoryFile == "" {
+			return fmt.Errorf("history store %s requires a history file", cfg.HistoryStore)
+		}
+	case types.HistoryStoreConfigMap:
+		if _, name := parseHistoryConfigMap(cfg.HistoryConfigMap); name == "" {
+			return fmt.Errorf("history store %s requires a history ConfigMap", cfg.HistoryStore)
+		}
+	default:
+		return fmt.Errorf("invalid history store %q, must be %s, %s or %s", cfg.HistoryStore,
+			types.HistoryStoreMemory, types.HistoryStoreFile, types.HistoryStoreConfigMap)
+	}
+	return nil
+}
+
+// parseHistoryConfigMap splits [namespace/]name, defaulting the namespace
+// to the pod's own
+func parseHistoryConfigMap(value string) (string, string) {
+	if namespace, name, ok := strings.Cut(value, "/"); ok {
+		return namespace, name
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace, value
+}
+
+// record appends transitions, dropping the oldest beyond the size, and
+// schedules a save
+func (h *versionHistory) record(entries []types.HistoryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	h.entries = append(h.entries, entries...)
+	if excess := len(h.entries) - h.size; excess > 0 {
+		h.entries = append([]types.HistoryEntry(nil), h.entries[excess:]...)
+	}
+	h.mu.Unlock()
+
+	if h.store != nil {
+		select {
+		case h.saveCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// query returns the entries matching q in time order
+func (h *versionHistory) query(q HistoryQuery) []types.HistoryEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	matched := []types.HistoryEntry{}
+	for _, entry := range h.entries {
+		if q.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	return matched
+}
+
+// matches reports whether an entry passes the query's filters. App and node
+// filters together match entries of either.
+func (q HistoryQuery) matches(entry types.HistoryEntry) bool {
+	if q.Apps != nil || q.Nodes != nil {
+		if !q.Apps[entry.App] && !q.Nodes[entry.Node] {
+			return false
+		}
+	}
+	if q.Namespaces != nil && !q.Namespaces[entry.Namespace] {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// load restores the persisted history, keeping the most recent entries
+func (h *versionHistory) load(ctx context.Context) error {
+	if h.store == nil {
+		return nil
+	}
+	entries, err := h.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if excess := len(entries) - h.size; excess > 0 {
+		entries = entries[excess:]
+	}
+
+	h.mu.Lock()
+	h.entries = append(entries, h.entries...)
+	h.mu.Unlock()
+	return nil
+}
+
+// save persists the current entries
+func (h *versionHistory) save(ctx context.Context) error {
+	h.mu.RLock()
+	entries := append([]types.HistoryEntry(nil), h.entries...)
+	h.mu.RUnlock()
+	return h.store.Save(ctx, entries)
+}
+
+// runHistoryStore saves the history after it changes until ctx is
+// cancelled, then saves it one last time
+func (cd *ClusterDiscovery) runHistoryStore(ctx context.Context) {
+	h := cd.history
+	logger := cd.logger.WithField("store", h.store.String())
+
+	for {
+		select {
+		case <-ctx.Done():
+			saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := h.save(saveCtx); err != nil {
+				logger.WithError(err).Warn("Failed to save version history")
+			}
+			cancel()
+			return
+		case <-h.saveCh:
+			if err := h.save(ctx); err != nil {
+				logger.WithError(err).Warn("Failed to save version history")
+			}
+		}
+	}
+}
+
+// GetHistory returns the recorded version transitions matching q, and false
+// if the history is disabled
+func (cd *ClusterDiscovery) GetHistory(q HistoryQuery) ([]types.HistoryEntry, bool) {
+	if cd.history == nil {
+		return nil, false
+	}
+	return cd.history.query(q), true
+}
+
+// diffHistory computes the version transitions of app instances and nodes
+// from one snapshot to the next
+func diffHistory(previous, next *types.ClusterInfo, at time.Time) []types.HistoryEntry {
+	if previous == nil {
+		return nil
+	}
+	var entries []types.HistoryEntry
+
+	oldInstances := instanceVersions(previous.Apps)
+	newInstances := instanceVersions(next.Apps)
+	for _, key := range sortedKeys(newInstances, oldInstances) {
+		old, current := oldInstances[key], newInstances[key]
+		from, to := old.versions(), current.versions()
+		if from == to {
+			continue
+		}
+		entry := current.entry
+		if entry.Name == "" {
+			entry = old.entry
+		}
+		entry.Time = at
+		entry.From = from
+		entry.To = to
+		entries = append(entries, entry)
+	}
+
+	oldNodes := make(map[string]types.Node, len(previous.Nodes))
+	for _, node := range previous.Nodes {
+		oldNodes[node.Name] = node
+	}
+	newNodes := make(map[string]types.Node, len(next.Nodes))
+	for _, node := range next.Nodes {
+		newNodes[node.Name] = node
+	}
+	for _, name := range sortedKeys(newNodes, oldNodes) {
+		old, current := oldNodes[name], newNodes[name]
+		if old.Version == current.Version {
+			continue
+		}
+		entries = append(entries, types.HistoryEntry{
+			Time: at,
+			Node: name,
+			Kind: "Node",
+			Name: name,
+			From: old.Version,
+			To:   current.Version,
+		})
+	}
+
+	return entries
+}
+
+// instanceHistory is the versions an object reported for an app
+type instanceHistory struct {
+	entry types.HistoryEntry
+	set   map[string]bool
+}
+
+// versions returns the versions sorted and comma-separated
+func (i instanceHistory) versions() string {
+	versions := make([]string, 0, len(i.set))
+	for v := range i.set {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+// instanceVersions groups the versions of every app instance by app and
+// source object
+func instanceVersions(apps []types.App) map[string]instanceHistory {
+	instances := make(map[string]instanceHistory)
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			key := strings.Join([]string{app.Name, instance.Namespace, instance.Kind, instance.Name}, "/")
+			i, ok := instances[key]
+			if !ok {
+				i = instanceHistory{
+					entry: types.HistoryEntry{
+						App:       app.Name,
+						Namespace: instance.Namespace,
+						Kind:      instance.Kind,
+						Name:      instance.Name,
+						Source:    instance.Source,
+					},
+					set: make(map[string]bool),
+				}
+				instances[key] = i
+			}
+			i.set[instance.Version] = true
+		}
+	}
+	return instances
+}
+
+// sortedKeys returns the keys of both maps, sorted
+func sortedKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fileHistoryStore persists the history as JSON in a local file
+type fileHistoryStore struct {
+	path string
+}
+
+func (s *fileHistoryStore) String() string {
+	return "file:" + s.path
+}
+
+func (s *fileHistoryStore) Load(ctx context.Context) ([]types.HistoryEntry, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	var entries []types.HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid history file %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+// Save writes a temporary file and renames it, so a crash never leaves a
+// partial history behind
+func (s *fileHistoryStore) Save(ctx context.Context, entries []types.HistoryEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".history-*")
+	if err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	return nil
+}
+
+// configMapHistoryStore persists the history as JSON in a ConfigMap
+type configMapHistoryStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (s *configMapHistoryStore) String() string {
+	return "configmap:" + s.namespace + "/" + s.name
+}
+
+func (s *configMapHistoryStore) Load(ctx context.Context) ([]types.HistoryEntry, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history ConfigMap: %w", err)
+	}
+	data, ok := cm.Data[historyConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+	var entries []types.HistoryEntry
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, fmt.Errorf("invalid history ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	return entries, nil
+}
+
+func (s *configMapHistoryStore) Save(ctx context.Context, entries []types.HistoryEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.namespace,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Data: map[string]string{historyConfigMapKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create history ConfigMap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get history ConfigMap: %w", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[historyConfigMapKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update history ConfigMap: %w", err)
+	}
+	return nil
+}
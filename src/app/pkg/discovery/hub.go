@@ -0,0 +1,309 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// hubRetryInterval is how long a hub waits before restarting the discovery
+// of a cluster that failed to start
+const hubRetryInterval = 30 * time.Second
+
+// clusterSource provides the snapshot of one cluster of a hub
+type clusterSource interface {
+	Start(ctx context.Context) error
+	Stop()
+	Reconfigure(cfg *types.Config)
+	GetClusterInfo() (*types.ClusterInfo, error)
+}
+
+// clusterSpec is a member cluster as configured, before it is connected
+type clusterSpec struct {
+	name     string
+	source   string
+	endpoint string
+}
+
+// hubMember is one cluster aggregated by a hub
+type hubMember struct {
+	clusterSpec
+	cluster clusterSource
+}
+
+// Hub aggregates the snapshots of several clusters, each discovered through
+// a kubeconfig context or fetched from the reflector running in it. A cluster
+// that cannot be reached is reported as unhealthy without affecting the others.
+type Hub struct {
+	config  *types.Config
+	logger  *logrus.Logger
+	members []*hubMember
+	stopCh  chan struct{}
+}
+
+// NewHub creates a hub for the clusters of the configuration
+func NewHub(cfg *types.Config, logger *logrus.Logger) (*Hub, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	specs, err := parseClusters(cfg.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	hub := &Hub{
+		config: cfg,
+		logger: logger,
+		stopCh: make(chan struct{}),
+	}
+	for _, spec := range specs {
+		cluster, err := newClusterSource(spec, memberConfig(cfg, spec.name), logger)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", spec.name, err)
+		}
+		hub.members = append(hub.members, &hubMember{clusterSpec: spec, cluster: cluster})
+	}
+	return hub, nil
+}
+
+// parseClusters parses member clusters of the form name=context or
+// name=URL. A name alone uses the kubeconfig context of that name, and an
+// empty context the default kubeconfig or in-cluster config.
+func parseClusters(defs []string) ([]clusterSpec, error) {
+	specs := make([]clusterSpec, 0, len(defs))
+	seen := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		name, endpoint, hasEndpoint := strings.Cut(strings.TrimSpace(def), "=")
+		if !hasEndpoint {
+			endpoint = name
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid cluster %q, expected name=context or name=URL", def)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate cluster %q", name)
+		}
+		seen[name] = true
+
+		spec := clusterSpec{name: name, source: types.MemberSourceKubeconfig, endpoint: endpoint}
+		if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+			spec.source = types.MemberSourceRemote
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// validateHubConfig rejects invalid member clusters and the settings that
+// write to a cluster, which a hub does not do
+func validateHubConfig(cfg *types.Config) error {
+	if len(cfg.Clusters) == 0 {
+		return nil
+	}
+	if _, err := parseClusters(cfg.Clusters); err != nil {
+		return err
+	}
+	if cfg.AppVersionStatus || cfg.GenerateAppVersions {
+		return errors.New("writing AppVersion status or generating AppVersions is not supported in hub mode")
+	}
+	return nil
+}
+
+// memberConfig derives the configuration of a cluster of a hub, which is
+// identified by its member name. Its history is not kept, as /history is not
+// served in hub mode.
+func memberConfig(cfg *types.Config, name string) *types.Config {
+	member := *cfg
+	member.Clusters = nil
+	member.HistorySize = 0
+	member.ClusterName = name
+	return &member
+}
+
+// newClusterSource connects to a member cluster
+func newClusterSource(spec clusterSpec, cfg *types.Config, logger *logrus.Logger) (clusterSource, error) {
+	if spec.source == types.MemberSourceRemote {
+		return newRemoteCluster(spec.endpoint, cfg, logger)
+	}
+
+	var restConfig *rest.Config
+	var err error
+	if spec.endpoint == "" {
+		restConfig, err = config.GetConfig()
+	} else {
+		restConfig, err = config.GetConfigWithContext(spec.endpoint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes config: %w", err)
+	}
+
+	cd, err := newClusterDiscovery(cfg, logger, restConfig)
+	if err != nil {
+		return nil, err
+	}
+	cd.hubMember = true
+	return cd, nil
+}
+
+// Start runs every member cluster until the context is cancelled or the hub
+// is stopped
+func (h *Hub) Start(ctx context.Context) error {
+	h.logger.WithField("clusters", len(h.members)).Info("Starting hub")
+
+	var wg sync.WaitGroup
+	for _, member := range h.members {
+		wg.Add(1)
+		go func(member *hubMember) {
+			defer wg.Done()
+			h.run(ctx, member)
+		}(member)
+	}
+	wg.Wait()
+	return nil
+}
+
+// run runs a member cluster, restarting it while it fails to start
+func (h *Hub) run(ctx context.Context, member *hubMember) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"cluster":  member.name,
+		"source":   member.source,
+		"endpoint": member.endpoint,
+	})
+	for {
+		logger.Info("Starting cluster")
+		err := member.cluster.Start(ctx)
+		if err == nil {
+			return
+		}
+		logger.WithError(err).Warn("Cluster failed, restarting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.stopCh:
+			return
+		case <-time.After(hubRetryInterval):
+		}
+	}
+}
+
+// Stop stops every member cluster
+func (h *Hub) Stop() {
+	close(h.stopCh)
+	for _, member := range h.members {
+		member.cluster.Stop()
+	}
+}
+
+// Reconfigure hands an updated configuration to every member cluster
+func (h *Hub) Reconfigure(cfg *types.Config) {
+	for _, member := range h.members {
+		member.cluster.Reconfigure(memberConfig(cfg, member.name))
+	}
+}
+
+// Clusters returns the state of every member cluster, in configuration order
+func (h *Hub) Clusters() []types.MemberCluster {
+	clusters := make([]types.MemberCluster, 0, len(h.members))
+	for _, member := range h.members {
+		info, err := member.cluster.GetClusterInfo()
+		cluster := types.MemberCluster{
+			Name:          member.name,
+			Source:        member.source,
+			Endpoint:      member.endpoint,
+			Healthy:       err == nil,
+			Stale:         info.Stale,
+			LastRefreshed: info.LastRefreshed,
+			Error:         info.LastError,
+		}
+		if err != nil {
+			cluster.Error = err.Error()
+			if info.LastError != "" {
+				cluster.Error += ": " + info.LastError
+			}
+		} else {
+			cluster.Info = info
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// HealthCheck reports healthy while at least one member cluster is
+func (h *Hub) HealthCheck(ctx context.Context) error {
+	var failed []string
+	for _, cluster := range h.Clusters() {
+		if cluster.Healthy {
+			return nil
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", cluster.Name, cluster.Error))
+	}
+	return fmt.Errorf("no cluster is healthy (%s)", strings.Join(failed, "; "))
+}
+
+// AggregateApps groups the apps of healthy clusters by namespace and name.
+// Apps are passed through match first, which may narrow or drop them.
+func AggregateApps(clusters []types.MemberCluster, match func(types.App) (types.App, bool)) []types.HubApp {
+	byKey := make(map[string]*types.HubApp)
+	for _, cluster := range clusters {
+		if cluster.Info == nil {
+			continue
+		}
+		for _, app := range cluster.Info.Apps {
+			if match != nil {
+				var ok bool
+				if app, ok = match(app); !ok {
+					continue
+				}
+			}
+
+			key := app.Namespace + "/" + app.Name
+			hubApp, ok := byKey[key]
+			if !ok {
+				hubApp = &types.HubApp{
+					Name:      app.Name,
+					Namespace: app.Namespace,
+					Clusters:  make(map[string]types.HubAppPlacement),
+				}
+				byKey[key] = hubApp
+			}
+			hubApp.Clusters[cluster.Name] = types.HubAppPlacement{
+				Version:  app.Version,
+				Variants: app.Variants,
+				Replicas: app.Replicas,
+			}
+		}
+	}
+
+	apps := make([]types.HubApp, 0, len(byKey))
+	for _, hubApp := range byKey {
+		seen := make(map[string]bool)
+		for _, placement := range hubApp.Clusters {
+			if !seen[placement.Version] {
+				seen[placement.Version] = true
+				hubApp.Versions = append(hubApp.Versions, placement.Version)
+			}
+		}
+		sort.Slice(hubApp.Versions, func(i, j int) bool {
+			return compareVersions(hubApp.Versions[i], hubApp.Versions[j]) > 0
+		})
+		apps = append(apps, *hubApp)
+	}
+
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].Name != apps[j].Name {
+			return apps[i].Name < apps[j].Name
+		}
+		return apps[i].Namespace < apps[j].Namespace
+	})
+	return apps
+}
@@ -0,0 +1,171 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// identityRefresh is how often the cluster identity is fetched from the API
+// server. The UID, version and API groups only change on upgrades, so they
+// are not worth a request on every rebuild.
+const identityRefresh = 10 * time.Minute
+
+// identityTimeout bounds the lookup of the kube-system namespace
+const identityTimeout = 10 * time.Second
+
+// identityRetry is the wait before fetching the identity again after a
+// failure, doubled on each further failure up to identityRefresh, so a
+// degraded API server does not stall every rebuild for identityTimeout
+const identityRetry = 15 * time.Second
+
+// DefaultAPIGroups are the API groups reported when installed, unless
+// configured otherwise
+var DefaultAPIGroups = []string{
+	"argoproj.io",
+	"cert-manager.io",
+	"cluster.grid.sce.com",
+	"config.openshift.io",
+	"gateway.networking.k8s.io",
+	"monitoring.coreos.com",
+	"networking.istio.io",
+	"snapshot.storage.k8s.io",
+}
+
+// refreshIdentity fetches the cluster identity if it is due, keeping the
+// previous one and backing off if the API server cannot be reached. The
+// distribution is detected from the current nodes on every call.
+func (cd *ClusterDiscovery) refreshIdentity(ctx context.Context) {
+	previous := cd.identity
+	var identity types.ClusterIdentity
+	if previous != nil {
+		identity = *previous
+	}
+
+	if !time.Now().Before(cd.identityNextFetch) {
+		ctx, cancel := context.WithTimeout(ctx, identityTimeout)
+		fetched, err := cd.fetchIdentity(ctx)
+		cancel()
+		if err != nil {
+			cd.identityFailures++
+			retry := identityRefresh
+			if cd.identityFailures <= 6 {
+				retry = min(identityRetry<<(cd.identityFailures-1), identityRefresh)
+			}
+			cd.identityNextFetch = time.Now().Add(retry)
+			cd.logger.WithError(err).WithField("retryIn", retry).Warn("Failed to fetch cluster identity")
+		} else {
+			identity = *fetched
+			cd.identityFailures = 0
+			cd.identityNextFetch = time.Now().Add(identityRefresh)
+		}
+	}
+
+	identity.Name = cd.config.ClusterName
+	identity.Distribution = cd.detectDistribution(&identity)
+	if previous == nil || previous.Distribution != identity.Distribution ||
+		previous.KubernetesVersion != identity.KubernetesVersion {
+		cd.logger.WithFields(logrus.Fields{
+			"name":         identity.Name,
+			"id":           identity.ID,
+			"version":      identity.KubernetesVersion,
+			"distribution": identity.Distribution,
+		}).Info("Cluster identity")
+	}
+	cd.identity = &identity
+}
+
+// fetchIdentity reads the kube-system namespace UID, the API server version
+// and the installed API groups of interest
+func (cd *ClusterDiscovery) fetchIdentity(ctx context.Context) (*types.ClusterIdentity, error) {
+	identity := &types.ClusterIdentity{}
+
+	ns, err := cd.clientset.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s namespace: %w", metav1.NamespaceSystem, err)
+	}
+	identity.ID = string(ns.UID)
+
+	version, err := cd.clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API server version: %w", err)
+	}
+	identity.KubernetesVersion = version.GitVersion
+
+	groups, err := cd.clientset.Discovery().ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API groups: %w", err)
+	}
+	wanted := make(map[string]bool, len(cd.config.APIGroups))
+	for _, group := range cd.config.APIGroups {
+		wanted[group] = true
+	}
+	for _, group := range groups.Groups {
+		if wanted[group.Name] {
+			identity.APIGroups = append(identity.APIGroups, group.Name)
+		}
+	}
+	sort.Strings(identity.APIGroups)
+
+	return identity, nil
+}
+
+// detectDistribution guesses the Kubernetes distribution from the API
+// groups, the API server version and the nodes' labels, annotations and
+// provider IDs
+func (cd *ClusterDiscovery) detectDistribution(identity *types.ClusterIdentity) string {
+	for _, group := range identity.APIGroups {
+		if group == "config.openshift.io" {
+			return types.DistributionOpenShift
+		}
+	}
+
+	version := identity.KubernetesVersion
+	switch {
+	case strings.Contains(version, "+k3s"):
+		return types.DistributionK3s
+	case strings.Contains(version, "-eks-"):
+		return types.DistributionEKS
+	case strings.Contains(version, "-gke."):
+		return types.DistributionGKE
+	}
+
+	var nodes []*corev1.Node
+	if cd.nodeLister != nil {
+		nodes, _ = cd.nodeLister.List(labels.Everything())
+	}
+	for _, node := range nodes {
+		if distribution := nodeDistribution(node); distribution != "" {
+			return distribution
+		}
+	}
+	return types.DistributionUnknown
+}
+
+// nodeDistribution returns the distribution a node reveals, if any
+func nodeDistribution(node *corev1.Node) string {
+	providerID := node.Spec.ProviderID
+	switch {
+	case strings.HasPrefix(providerID, "k3s://"), strings.Contains(node.Status.NodeInfo.KubeletVersion, "+k3s"):
+		return types.DistributionK3s
+	case node.Labels["node.openshift.io/os_id"] != "":
+		return types.DistributionOpenShift
+	case node.Labels["eks.amazonaws.com/nodegroup"] != "" || node.Labels["eks.amazonaws.com/compute-type"] != "":
+		return types.DistributionEKS
+	case node.Labels["kubernetes.azure.com/cluster"] != "" || strings.HasPrefix(providerID, "azure://"):
+		return types.DistributionAKS
+	case node.Labels["cloud.google.com/gke-nodepool"] != "" || strings.HasPrefix(providerID, "gce://"):
+		return types.DistributionGKE
+	case node.Annotations["kubeadm.alpha.kubernetes.io/cri-socket"] != "":
+		return types.DistributionKubeadm
+	}
+	return ""
+}
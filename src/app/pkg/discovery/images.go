@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultRegistry is assumed for image references without a registry host
+const defaultRegistry = "docker.io"
+
+// imageReference is a parsed container image reference
+type imageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference splits an image reference such as
+// registry:5000/team/app:1.2.3@sha256:... into its parts. The first path
+// component is only taken as the registry if it looks like a host, that is it
+// contains a dot or a port, or is localhost.
+func parseImageReference(image string) (imageReference, error) {
+	ref := imageReference{}
+	remainder := strings.TrimSpace(image)
+	if remainder == "" {
+		return ref, fmt.Errorf("empty image reference")
+	}
+
+	if name, digest, ok := strings.Cut(remainder, "@"); ok {
+		if !strings.Contains(digest, ":") {
+			return ref, fmt.Errorf("invalid digest in image reference %q", image)
+		}
+		remainder, ref.Digest = name, digest
+	}
+
+	// A tag can only follow the last path component, so a colon before the
+	// last slash belongs to a registry port
+	if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		remainder, ref.Tag = remainder[:i], remainder[i+1:]
+		if ref.Tag == "" {
+			return ref, fmt.Errorf("empty tag in image reference %q", image)
+		}
+	}
+
+	ref.Registry = defaultRegistry
+	if first, rest, ok := strings.Cut(remainder, "/"); ok &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, remainder = first, rest
+	}
+
+	if remainder == "" || strings.HasPrefix(remainder, "/") || strings.HasSuffix(remainder, "/") {
+		return ref, fmt.Errorf("invalid repository in image reference %q", image)
+	}
+	ref.Repository = remainder
+
+	return ref, nil
+}
+
+// version returns the tag, else the digest, else "latest" as the registry would
+func (r imageReference) version() string {
+	switch {
+	case r.Tag != "":
+		return r.Tag
+	case r.Digest != "":
+		return r.Digest
+	default:
+		return "latest"
+	}
+}
+
+// containerImage returns a container's image and its digest, if the
+// reference pins one
+func containerImage(container *corev1.Container) (string, string) {
+	if container == nil {
+		return "", ""
+	}
+	image := container.Image
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return image, ""
+	}
+	return image, ref.Digest
+}
+
+// imageIDDigest extracts the digest from a container status image ID such
+// as docker-pullable://registry/app@sha256:...
+func imageIDDigest(imageID string) string {
+	if _, digest, ok := strings.Cut(imageID, "@"); ok {
+		return digest
+	}
+	return ""
+}
+
+// parseImageNamePattern compiles the regex used by the regex image name mode
+func parseImageNamePattern(cfg *types.Config) (*regexp.Regexp, error) {
+	switch cfg.ImageName {
+	case types.ImageNameLast, types.ImageNameRepository:
+		return nil, nil
+	case types.ImageNameRegex:
+		if cfg.ImageNamePattern == "" {
+			return nil, fmt.Errorf("image name mode %s requires an image name pattern", types.ImageNameRegex)
+		}
+		pattern, err := regexp.Compile(cfg.ImageNamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image name pattern %q: %w", cfg.ImageNamePattern, err)
+		}
+		if pattern.NumSubexp() < 1 {
+			return nil, fmt.Errorf("image name pattern %q has no capture group", cfg.ImageNamePattern)
+		}
+		return pattern, nil
+	default:
+		return nil, fmt.Errorf("invalid image name mode %q, must be %s, %s or %s", cfg.ImageName,
+			types.ImageNameLast, types.ImageNameRepository, types.ImageNameRegex)
+	}
+}
+
+// imageAppName derives an app name from an image reference according to the
+// configured image name mode
+func (cd *ClusterDiscovery) imageAppName(ref imageReference) string {
+	last := ref.Repository[strings.LastIndex(ref.Repository, "/")+1:]
+
+	switch cd.config.ImageName {
+	case types.ImageNameRepository:
+		return ref.Repository
+	case types.ImageNameRegex:
+		// Matched against registry/repository, using the group named "name"
+		// if there is one and the first group otherwise
+		match := cd.imageNamePattern.FindStringSubmatch(ref.Registry + "/" + ref.Repository)
+		if match == nil {
+			return last
+		}
+		group := 1
+		if i := cd.imageNamePattern.SubexpIndex("name"); i > 0 {
+			group = i
+		}
+		if match[group] != "" {
+			return match[group]
+		}
+		return last
+	default:
+		return last
+	}
+}
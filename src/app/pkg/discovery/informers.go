@@ -0,0 +1,216 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerResync is the resync period for the shared informers. Resyncs are
+// disabled because the rebuild loop already refreshes the snapshot from the
+// informer caches on every tick.
+const informerResync = 0
+
+// Informer sources, used to decide which part of the snapshot to rebuild
+const (
+	sourceNodes = "nodes"
+	sourceApps  = "apps"
+)
+
+// appVersionGVR identifies the AppVersion custom resource
+var appVersionGVR = schema.GroupVersionResource{
+	Group:    "cluster.grid.sce.com",
+	Version:  "v1alpha1",
+	Resource: "appversions",
+}
+
+// setupInformers registers the shared informers needed by the current
+// configuration on new factories. Listers left from an earlier attempt, such
+// as a hub retrying Start, belong to factories that were never started and
+// are dropped so their informers are registered again.
+func (cd *ClusterDiscovery) setupInformers() error {
+	cd.informerFactory = informers.NewSharedInformerFactory(cd.clientset, informerResync)
+	cd.dynamicFactory = dynamicinformer.NewDynamicSharedInformerFactory(cd.dynamicClient, informerResync)
+	cd.informersSynced = make(map[string]cache.InformerSynced)
+	cd.workloadInformers = make(map[string]cache.SharedIndexInformer)
+	cd.nodeLister = nil
+	cd.namespaceLister = nil
+	cd.appVersionLister = nil
+	cd.podInformer = nil
+
+	// Nodes are always watched
+	nodeInformer := cd.informerFactory.Core().V1().Nodes()
+	if err := cd.addEventHandler("nodes", nodeInformer.Informer(), sourceNodes); err != nil {
+		return err
+	}
+	cd.nodeLister = nodeInformer.Lister()
+
+	if !cd.config.AppDiscoveryEnabled {
+		cd.logger.Info("App discovery disabled, only watching nodes")
+		return nil
+	}
+
+	// Namespaces, only if app discovery is limited to some of them
+	if _, err := cd.setupNamespaceInformer(); err != nil {
+		return err
+	}
+
+	// AppVersion CRDs, only if the CRD is actually served
+	if cd.config.PreferCRD {
+		served, err := cd.resourceServed(appVersionGVR)
+		if err != nil {
+			return err
+		}
+		if served {
+			appVersionInformer := cd.dynamicFactory.ForResource(appVersionGVR)
+			if err := cd.addEventHandler("appversions", appVersionInformer.Informer(), sourceApps); err != nil {
+				return err
+			}
+			cd.appVersionLister = appVersionInformer.Lister()
+		} else {
+			cd.logger.WithField("resource", appVersionGVR.String()).Warn("AppVersion CRD is not installed, CRD discovery disabled")
+		}
+	}
+
+	_, err := cd.setupWorkloadInformers()
+	return err
+}
+
+// addEventHandler wires an informer into the rebuild loop and tracks its sync state
+func (cd *ClusterDiscovery) addEventHandler(name string, informer cache.SharedIndexInformer, source string) error {
+	return cd.addFilteredEventHandler(name, informer, source, sameResourceVersion)
+}
+
+// addFilteredEventHandler is addEventHandler for informers whose updates
+// only need a rebuild when unchanged reports a difference that matters
+func (cd *ClusterDiscovery) addFilteredEventHandler(name string, informer cache.SharedIndexInformer, source string, unchanged func(oldObj, newObj interface{}) bool) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cd.markDirty(source)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if unchanged(oldObj, newObj) {
+				return
+			}
+			cd.markDirty(source)
+		},
+		DeleteFunc: func(obj interface{}) {
+			cd.markDirty(source)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s event handler: %w", name, err)
+	}
+
+	cd.informersMutex.Lock()
+	cd.informersSynced[name] = informer.HasSynced
+	cd.informersMutex.Unlock()
+	cd.logger.WithField("informer", name).Debug("Registered informer")
+	return nil
+}
+
+// resourceServed checks whether the API server serves a resource, so that
+// informers are not started for CRDs that are not installed
+func (cd *ClusterDiscovery) resourceServed(gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := cd.clientset.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to discover %s: %w", gvr.String(), err)
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// markDirty flags part of the snapshot for rebuild and wakes up the rebuild loop
+func (cd *ClusterDiscovery) markDirty(source string) {
+	switch source {
+	case sourceNodes:
+		cd.nodesDirty.Store(true)
+	case sourceApps:
+		cd.appsDirty.Store(true)
+	}
+
+	// Non-blocking send, a pending signal already covers this change
+	select {
+	case cd.changeCh <- struct{}{}:
+	default:
+	}
+}
+
+// syncFuncs returns the sync functions of all registered informers
+func (cd *ClusterDiscovery) syncFuncs() []cache.InformerSynced {
+	cd.informersMutex.RLock()
+	defer cd.informersMutex.RUnlock()
+
+	funcs := make([]cache.InformerSynced, 0, len(cd.informersSynced))
+	for _, synced := range cd.informersSynced {
+		funcs = append(funcs, synced)
+	}
+	return funcs
+}
+
+// unsyncedInformers returns the names of informers that have not synced yet
+func (cd *ClusterDiscovery) unsyncedInformers() []string {
+	cd.informersMutex.RLock()
+	defer cd.informersMutex.RUnlock()
+
+	var pending []string
+	for name, synced := range cd.informersSynced {
+		if !synced() {
+			pending = append(pending, name)
+		}
+	}
+	return pending
+}
+
+// logInformers logs the set of registered informers
+func (cd *ClusterDiscovery) logInformers() {
+	cd.informersMutex.RLock()
+	defer cd.informersMutex.RUnlock()
+
+	names := make([]string, 0, len(cd.informersSynced))
+	for name := range cd.informersSynced {
+		names = append(names, name)
+	}
+	cd.logger.WithFields(logrus.Fields{
+		"informers": names,
+	}).Info("Informer caches synced")
+}
+
+// sameResourceVersion reports whether an update event is a no-op resync
+func sameResourceVersion(oldObj, newObj interface{}) bool {
+	oldMeta, ok := oldObj.(interface{ GetResourceVersion() string })
+	if !ok {
+		return false
+	}
+	newMeta, ok := newObj.(interface{ GetResourceVersion() string })
+	if !ok {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
+// unstructuredItems converts lister results into unstructured objects
+func unstructuredItems(objs []runtime.Object) []*unstructured.Unstructured {
+	items := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			items = append(items, u)
+		}
+	}
+	return items
+}
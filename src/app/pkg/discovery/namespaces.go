@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceFilter decides which namespaces app discovery looks at
+type namespaceFilter struct {
+	selector labels.Selector // nil when no label selector is configured
+	include  map[string]bool
+	exclude  map[string]bool
+}
+
+// parseNamespaceFilter builds a namespace filter from the configuration.
+// The selector is always a Kubernetes label selector such as
+// "grid.sce.com/env in (production,staging),!legacy"; namespaces are named
+// through the include and exclude lists. A selector made only of bare names,
+// which older releases read as namespace names, is rejected rather than
+// silently matching namespaces that carry such labels.
+func parseNamespaceFilter(cfg *types.Config) (*namespaceFilter, error) {
+	filter := &namespaceFilter{
+		include: make(map[string]bool),
+		exclude: make(map[string]bool),
+	}
+
+	if selector := strings.TrimSpace(cfg.NamespaceSelector); selector != "" {
+		if namespaceNameList(selector) {
+			return nil, fmt.Errorf("namespace selector %q is a list of namespace names, which is no longer accepted: "+
+				"list them with --namespace-include instead, or use a prefixed label key to select by label existence", selector)
+		}
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+		}
+		filter.selector = parsed
+	}
+
+	for _, name := range cfg.NamespaceInclude {
+		filter.include[strings.TrimSpace(name)] = true
+	}
+	for _, name := range cfg.NamespaceExclude {
+		filter.exclude[strings.TrimSpace(name)] = true
+	}
+
+	return filter, nil
+}
+
+// namespaceNameList reports whether a selector has only bare terms that are
+// valid namespace names, with no operators or prefixed label keys
+func namespaceNameList(selector string) bool {
+	for _, term := range strings.Split(selector, ",") {
+		if len(validation.IsDNS1123Label(strings.TrimSpace(term))) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// all reports whether the filter selects every namespace
+func (f *namespaceFilter) all() bool {
+	return f.selector == nil && len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// matches reports whether a namespace is selected
+func (f *namespaceFilter) matches(ns *corev1.Namespace) bool {
+	if f.exclude[ns.Name] {
+		return false
+	}
+	if f.include[ns.Name] {
+		return true
+	}
+	if f.selector != nil {
+		return f.selector.Matches(labels.Set(ns.Labels))
+	}
+	// Only an exclude list is configured
+	return len(f.include) == 0
+}
+
+// setupNamespaceInformer starts watching namespaces once a namespace filter
+// needs them, returning the sync function if a new informer was registered
+func (cd *ClusterDiscovery) setupNamespaceInformer() (cache.InformerSynced, error) {
+	if cd.namespaceLister != nil || cd.namespaceFilter.all() {
+		return nil, nil
+	}
+
+	namespaceInformer := cd.informerFactory.Core().V1().Namespaces()
+	if err := cd.addEventHandler("namespaces", namespaceInformer.Informer(), sourceApps); err != nil {
+		return nil, err
+	}
+	cd.namespaceLister = namespaceInformer.Lister()
+	return namespaceInformer.Informer().HasSynced, nil
+}
+
+// resolveNamespaces returns the namespaces to discover apps in, where a
+// single empty string means all namespaces
+func (cd *ClusterDiscovery) resolveNamespaces() []string {
+	if cd.namespaceFilter.all() {
+		return []string{""}
+	}
+
+	namespaceList, err := cd.namespaceLister.List(labels.Everything())
+	if err != nil {
+		cd.logger.WithError(err).Error("Failed to list namespaces")
+		return []string{}
+	}
+
+	namespaces := make([]string, 0, len(namespaceList))
+	for _, ns := range namespaceList {
+		if cd.namespaceFilter.matches(ns) {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	sort.Strings(namespaces)
+
+	cd.logger.WithField("namespaces", namespaces).Debug("Resolved namespace selector")
+	return namespaces
+}
@@ -0,0 +1,251 @@
+package discovery
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodeRoleLabelPrefix prefixes the labels naming a node's roles, as shown
+// by kubectl get nodes
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// defaultNodeRoleRules are tried after any configured rules and keep the
+// original classification: control plane by label or NoSchedule taint, else
+// worker
+var defaultNodeRoleRules = []types.NodeRoleRule{
+	{Role: types.NodeRoleControlPlane, Label: nodeRoleLabelPrefix + "control-plane"},
+	{Role: types.NodeRoleControlPlane, Label: nodeRoleLabelPrefix + "master"},
+	{Role: types.NodeRoleControlPlane, Taint: nodeRoleLabelPrefix + "control-plane", Effect: string(corev1.TaintEffectNoSchedule)},
+	{Role: types.NodeRoleControlPlane, Taint: nodeRoleLabelPrefix + "master", Effect: string(corev1.TaintEffectNoSchedule)},
+}
+
+// validateNodeRoleRules checks that every rule names a role and matches
+// exactly one of a label or a taint
+func validateNodeRoleRules(rules []types.NodeRoleRule) error {
+	for i, rule := range rules {
+		if rule.Role == "" {
+			return fmt.Errorf("node role rule %d: no role", i+1)
+		}
+		if (rule.Label == "") == (rule.Taint == "") {
+			return fmt.Errorf("node role rule %d (%s): must set exactly one of label or taint", i+1, rule.Role)
+		}
+		if rule.Effect != "" {
+			if rule.Label != "" {
+				return fmt.Errorf("node role rule %d (%s): effect only applies to taints", i+1, rule.Role)
+			}
+			switch corev1.TaintEffect(rule.Effect) {
+			case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			default:
+				return fmt.Errorf("node role rule %d (%s): invalid taint effect %q", i+1, rule.Role, rule.Effect)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeRoles returns the primary role and all roles of a node. The primary
+// role is that of the first matching rule, configured rules first, else
+// worker. The roles add every node-role label and matching rule.
+func nodeRoles(node *corev1.Node, configured []types.NodeRoleRule) (string, []string) {
+	primary := ""
+	seen := make(map[string]bool)
+	var roles []string
+	add := func(role string) {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	for _, rules := range [][]types.NodeRoleRule{configured, defaultNodeRoleRules} {
+		for _, rule := range rules {
+			if !matchNodeRoleRule(node, rule) {
+				continue
+			}
+			if primary == "" {
+				primary = rule.Role
+			}
+			add(rule.Role)
+		}
+	}
+	if primary == "" {
+		primary = types.NodeRoleWorker
+		add(primary)
+	}
+
+	for key := range node.Labels {
+		if role, ok := strings.CutPrefix(key, nodeRoleLabelPrefix); ok {
+			add(role)
+		}
+	}
+
+	sort.Strings(roles)
+	return primary, roles
+}
+
+// matchNodeRoleRule reports whether a node has the label or taint of a rule
+func matchNodeRoleRule(node *corev1.Node, rule types.NodeRoleRule) bool {
+	if rule.Label != "" {
+		value, ok := node.Labels[rule.Label]
+		return ok && (rule.Value == "" || value == rule.Value)
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != rule.Taint {
+			continue
+		}
+		if (rule.Value == "" || taint.Value == rule.Value) && (rule.Effect == "" || string(taint.Effect) == rule.Effect) {
+			return true
+		}
+	}
+	return false
+}
+
+// Topology labels, with the deprecated beta labels still set by older
+// cloud providers as a fallback
+const (
+	zoneLabel       = "topology.kubernetes.io/zone"
+	regionLabel     = "topology.kubernetes.io/region"
+	betaZoneLabel   = "failure-domain.beta.kubernetes.io/zone"
+	betaRegionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+// addressPreference is a parsed entry of the node IP preference, matching
+// addresses of a type and, if set, a family
+type addressPreference struct {
+	addressType corev1.NodeAddressType
+	family      string
+}
+
+// nodeAddressTypes lists the address types a node can report
+var nodeAddressTypes = []corev1.NodeAddressType{
+	corev1.NodeInternalIP,
+	corev1.NodeExternalIP,
+	corev1.NodeHostName,
+	corev1.NodeInternalDNS,
+	corev1.NodeExternalDNS,
+}
+
+// DefaultNodeIPPreference keeps the first internal IP as a node's IP,
+// falling back to the first external IP for nodes without one
+var DefaultNodeIPPreference = []string{string(corev1.NodeInternalIP), string(corev1.NodeExternalIP)}
+
+// parseNodeIPPreference parses node IP preference entries of the form Type
+// or Type/Family, such as InternalIP or InternalIP/IPv6. No entries means
+// the default preference.
+func parseNodeIPPreference(entries []string) ([]addressPreference, error) {
+	if len(entries) == 0 {
+		entries = DefaultNodeIPPreference
+	}
+	preference := make([]addressPreference, 0, len(entries))
+	for _, entry := range entries {
+		addressType, family, _ := strings.Cut(strings.TrimSpace(entry), "/")
+		pref := addressPreference{addressType: corev1.NodeAddressType(addressType), family: family}
+		if !slices.Contains(nodeAddressTypes, pref.addressType) {
+			return nil, fmt.Errorf("invalid node IP preference %q, unknown address type %q", entry, addressType)
+		}
+		if family != "" && family != types.AddressFamilyIPv4 && family != types.AddressFamilyIPv6 {
+			return nil, fmt.Errorf("invalid node IP preference %q, family must be %s or %s",
+				entry, types.AddressFamilyIPv4, types.AddressFamilyIPv6)
+		}
+		preference = append(preference, pref)
+	}
+	return preference, nil
+}
+
+// nodeAddresses returns the preferred address of a node and all of its
+// addresses with their families. The preferred address is the first one
+// matching an entry of the preference, tried in order, else empty.
+func nodeAddresses(node *corev1.Node, preference []addressPreference) (string, []types.NodeAddress) {
+	addresses := make([]types.NodeAddress, 0, len(node.Status.Addresses))
+	for _, addr := range node.Status.Addresses {
+		address := types.NodeAddress{Type: string(addr.Type), Address: addr.Address}
+		if ip, err := netip.ParseAddr(addr.Address); err == nil {
+			address.Family = types.AddressFamilyIPv6
+			if ip.Unmap().Is4() {
+				address.Family = types.AddressFamilyIPv4
+			}
+		}
+		addresses = append(addresses, address)
+	}
+
+	for _, pref := range preference {
+		for _, address := range addresses {
+			if address.Type == string(pref.addressType) && (pref.family == "" || address.Family == pref.family) {
+				return address.Address, addresses
+			}
+		}
+	}
+	return "", addresses
+}
+
+// nodeDetails returns the health, resources, platform and scheduling state
+// of a node. Condition heartbeat and transition times are left out, as they
+// change on every kubelet status update.
+func nodeDetails(node *corev1.Node) *types.NodeDetails {
+	info := node.Status.NodeInfo
+	details := &types.NodeDetails{
+		Conditions:       make([]types.NodeCondition, 0, len(node.Status.Conditions)),
+		Capacity:         nodeResources(node.Status.Capacity),
+		Allocatable:      nodeResources(node.Status.Allocatable),
+		OSImage:          info.OSImage,
+		KernelVersion:    info.KernelVersion,
+		ContainerRuntime: info.ContainerRuntimeVersion,
+		OperatingSystem:  info.OperatingSystem,
+		Architecture:     info.Architecture,
+		Zone:             firstLabel(node.Labels, zoneLabel, betaZoneLabel),
+		Region:           firstLabel(node.Labels, regionLabel, betaRegionLabel),
+		Unschedulable:    node.Spec.Unschedulable,
+	}
+
+	for _, condition := range node.Status.Conditions {
+		details.Conditions = append(details.Conditions, types.NodeCondition{
+			Type:   string(condition.Type),
+			Status: string(condition.Status),
+			Reason: condition.Reason,
+		})
+		if condition.Type == corev1.NodeReady {
+			details.Ready = condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	for _, taint := range node.Spec.Taints {
+		details.Taints = append(details.Taints, types.NodeTaint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: string(taint.Effect),
+		})
+	}
+
+	return details
+}
+
+// nodeResources returns the CPU, memory and pod quantities of a resource list
+func nodeResources(list corev1.ResourceList) types.NodeResources {
+	var resources types.NodeResources
+	if cpu, ok := list[corev1.ResourceCPU]; ok {
+		resources.CPU = cpu.String()
+	}
+	if memory, ok := list[corev1.ResourceMemory]; ok {
+		resources.Memory = memory.String()
+	}
+	if pods, ok := list[corev1.ResourcePods]; ok {
+		resources.Pods = pods.String()
+	}
+	return resources
+}
+
+// firstLabel returns the value of the first of the labels that is set
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := labels[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package discovery
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ownerLookupKinds are intermediate controllers between a workload and its
+// pods. Their informers are needed to resolve pods back to the workload even
+// when the kinds are not discovered themselves.
+var ownerLookupKinds = []string{"ReplicaSet", "Job"}
+
+// podIndex maps top-level workloads to their running pods
+type podIndex map[string][]*corev1.Pod
+
+// workloadKey identifies a workload in a podIndex
+func workloadKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// setupPodInformers registers the pod informer and the owner lookup
+// informers for pod-level discovery
+func (cd *ClusterDiscovery) setupPodInformers() ([]cache.InformerSynced, error) {
+	if !cd.config.PodVersions || cd.podInformer != nil {
+		return nil, nil
+	}
+
+	var added []cache.InformerSynced
+	for _, kind := range ownerLookupKinds {
+		if _, exists := cd.workloadInformers[kind]; exists {
+			continue
+		}
+		source := builtinWorkloadSources[kind]
+		informer := source.informer(cd)
+		if err := cd.addEventHandler(source.resource, informer, sourceApps); err != nil {
+			return nil, err
+		}
+		cd.workloadInformers[kind] = informer
+		added = append(added, informer.HasSynced)
+	}
+
+	podInformer := cd.informerFactory.Core().V1().Pods().Informer()
+	// Only the fields used for version discovery are kept in the cache. The
+	// transform cannot be set on an informer that was started by an update
+	// that was rolled back, which then caches whole pods.
+	if err := podInformer.SetTransform(trimPod); err != nil {
+		cd.logger.WithError(err).Warn("Failed to set pod transform, caching whole pods")
+	}
+	// Pod status updates, such as probe results, are frequent on large
+	// clusters and only rebuild the apps when they change what is reported
+	if err := cd.addFilteredEventHandler("pods", podInformer, sourceApps, samePodVersion); err != nil {
+		return nil, err
+	}
+	cd.podInformer = podInformer
+	added = append(added, podInformer.HasSynced)
+
+	return added, nil
+}
+
+// trimPod drops the parts of a pod that pod-level discovery does not use
+func trimPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+
+	containers := make([]corev1.Container, 0, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, corev1.Container{Name: c.Name, Image: c.Image})
+	}
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.ContainerStatuses))
+	for _, s := range pod.Status.ContainerStatuses {
+		statuses = append(statuses, corev1.ContainerStatus{Name: s.Name, ImageID: s.ImageID})
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			Labels:            pod.Labels,
+			Annotations:       pod.Annotations,
+			OwnerReferences:   pod.OwnerReferences,
+			ResourceVersion:   pod.ResourceVersion,
+			DeletionTimestamp: pod.DeletionTimestamp,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   pod.Spec.NodeName,
+			Containers: containers,
+		},
+		Status: corev1.PodStatus{
+			Phase:             pod.Status.Phase,
+			Conditions:        pod.Status.Conditions,
+			ContainerStatuses: statuses,
+		},
+	}, nil
+}
+
+// samePodVersion reports whether a pod update leaves everything version
+// discovery reads from the pod unchanged: its labels and annotations, owner,
+// phase, deletion, readiness and container images
+func samePodVersion(oldObj, newObj interface{}) bool {
+	if sameResourceVersion(oldObj, newObj) {
+		return true
+	}
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+
+	return maps.Equal(oldPod.Labels, newPod.Labels) &&
+		maps.Equal(oldPod.Annotations, newPod.Annotations) &&
+		reflect.DeepEqual(metav1.GetControllerOf(oldPod), metav1.GetControllerOf(newPod)) &&
+		(oldPod.DeletionTimestamp == nil) == (newPod.DeletionTimestamp == nil) &&
+		oldPod.Status.Phase == newPod.Status.Phase &&
+		podReady(oldPod) == podReady(newPod) &&
+		slices.EqualFunc(oldPod.Spec.Containers, newPod.Spec.Containers, func(a, b corev1.Container) bool {
+			return a.Name == b.Name && a.Image == b.Image
+		}) &&
+		slices.EqualFunc(oldPod.Status.ContainerStatuses, newPod.Status.ContainerStatuses, func(a, b corev1.ContainerStatus) bool {
+			return a.Name == b.Name && a.ImageID == b.ImageID
+		})
+}
+
+// buildPodIndex groups running pods in the given namespaces by the workload
+// that ultimately controls them
+func (cd *ClusterDiscovery) buildPodIndex(namespaces []string, rec *sourceRecorder) podIndex {
+	index := make(podIndex)
+	if cd.podInformer == nil {
+		rec.skip(types.SourceKindPods, "pods", "pod informer is not running")
+		return index
+	}
+
+	addPod := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			return
+		}
+		kind, name, ok := cd.controllingWorkload(pod)
+		if !ok {
+			return
+		}
+		key := workloadKey(kind, pod.Namespace, name)
+		index[key] = append(index[key], pod)
+	}
+
+	for _, ns := range namespaces {
+		start := time.Now()
+		indexed := len(index)
+		var err error
+		if ns == "" {
+			err = cache.ListAll(cd.podInformer.GetIndexer(), labels.Everything(), addPod)
+		} else {
+			err = cache.ListAllByNamespace(cd.podInformer.GetIndexer(), ns, labels.Everything(), addPod)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to list pods: %w", err)
+			cd.logger.WithError(err).WithField("namespace", ns).Error("Failed to list pods")
+		}
+		// Items counts the workloads found running pods
+		rec.record(types.SourceKindPods, "pods", time.Since(start), len(index)-indexed, err)
+		if ns != "" {
+			rec.record(types.SourceKindNamespace, ns, time.Since(start), 0, err)
+		}
+	}
+
+	return index
+}
+
+// controllingWorkload follows a pod's controller references through
+// ReplicaSets and Jobs up to the workload that owns them
+func (cd *ClusterDiscovery) controllingWorkload(pod *corev1.Pod) (string, string, bool) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", "", false
+	}
+	kind, name := ref.Kind, ref.Name
+
+	// A Deployment or Rollout owns pods through a ReplicaSet, a CronJob
+	// through a Job
+	informer, ok := cd.workloadInformers[kind]
+	if ok && (kind == "ReplicaSet" || kind == "Job") {
+		obj, exists, err := informer.GetIndexer().GetByKey(pod.Namespace + "/" + name)
+		if err == nil && exists {
+			if owner, ok := obj.(metav1.Object); ok {
+				if ownerRef := metav1.GetControllerOf(owner); ownerRef != nil {
+					kind, name = ownerRef.Kind, ownerRef.Name
+				}
+			}
+		}
+	}
+
+	return kind, name, true
+}
+
+// processWorkloadPods adds the versions served by a workload's running pods
+// to the app the workload belongs to, with replica and readiness counts
+func (cd *ClusterDiscovery) processWorkloadPods(kind string, workload metav1.Object, containers []corev1.Container, pods []*corev1.Pod, appMap map[string]*types.App) {
+	found, ok := cd.extractApp(kind, workload, containers)
+	if !ok {
+		return
+	}
+
+	observedAt := time.Now()
+	for _, pod := range pods {
+		container := found.rule.selectContainer(pod.Spec.Containers)
+		version := cd.podVersion(found.rule, pod, container)
+		image, digest := podImage(pod, container)
+		ready := podReady(pod)
+		instance := types.AppInstance{
+			Namespace:  workload.GetNamespace(),
+			Source:     types.AppSourcePods,
+			Rule:       found.rule.name,
+			Kind:       kind,
+			Name:       workload.GetName(),
+			Version:    version,
+			Image:      image,
+			Digest:     digest,
+			ObservedAt: observedAt,
+			Replicas:   1,
+		}
+		if ready {
+			instance.Ready = 1
+		}
+		app := cd.addAppInstance(appMap, found.name, instance)
+		app.Replicas = addReplica(app.Replicas, version, ready)
+	}
+}
+
+// podVersion returns the version a pod is running, from the rule that named
+// its workload evaluated against the pod, or else its container image tag
+func (cd *ClusterDiscovery) podVersion(rule *extractionRule, pod *corev1.Pod, container *corev1.Container) string {
+	if version, _ := cd.extractValue(rule.version, pod, container, true); version != "" {
+		return version
+	}
+	if container != nil {
+		if _, tag := cd.parseImageTag(container.Image); tag != "" {
+			return tag
+		}
+	}
+	return "unknown"
+}
+
+// podImage returns the image of a pod's container and the digest it is
+// actually running, as resolved by the container runtime
+func podImage(pod *corev1.Pod, container *corev1.Container) (string, string) {
+	image, digest := containerImage(container)
+	if image == "" || digest != "" {
+		return image, digest
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container.Name {
+			return image, imageIDDigest(status.ImageID)
+		}
+	}
+	return image, ""
+}
+
+// podReady reports whether a pod's Ready condition is true
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// addReplica counts one pod towards a version's replicas
+func addReplica(replicas []types.VersionReplicas, version string, ready bool) []types.VersionReplicas {
+	for i := range replicas {
+		if replicas[i].Version == version {
+			replicas[i].Replicas++
+			if ready {
+				replicas[i].Ready++
+			}
+			return replicas
+		}
+	}
+
+	entry := types.VersionReplicas{Version: version, Replicas: 1}
+	if ready {
+		entry.Ready = 1
+	}
+	return append(replicas, entry)
+}
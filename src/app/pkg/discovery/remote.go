@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// remoteTimeout bounds each fetch of a remote reflector's snapshot
+const remoteTimeout = 10 * time.Second
+
+// remoteCluster is a member cluster of a hub whose snapshot is fetched from
+// the reflector running in it. /cluster-info is polled every half cache TTL
+// with conditional requests, and the snapshot turns stale like a local one
+// once it could not be fetched for a whole TTL.
+type remoteCluster struct {
+	url    string
+	client *http.Client
+	logger *logrus.Entry
+
+	mu        sync.RWMutex
+	config    *types.Config
+	info      *types.ClusterInfo
+	etag      string
+	fetchedAt time.Time
+	lastError string
+
+	reconfigureCh chan *types.Config
+	stopCh        chan struct{}
+}
+
+// newRemoteCluster creates a member cluster for the reflector at a base URL
+func newRemoteCluster(endpoint string, cfg *types.Config, logger *logrus.Logger) (*remoteCluster, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid reflector URL %q", endpoint)
+	}
+
+	return &remoteCluster{
+		url:           strings.TrimSuffix(endpoint, "/") + "/cluster-info",
+		client:        &http.Client{Timeout: remoteTimeout},
+		logger:        logger.WithField("url", endpoint),
+		config:        cfg,
+		reconfigureCh: make(chan *types.Config, 1),
+		stopCh:        make(chan struct{}),
+	}, nil
+}
+
+// Start polls the remote reflector until the context is cancelled or the
+// cluster is stopped
+func (r *remoteCluster) Start(ctx context.Context) error {
+	r.fetch(ctx)
+
+	ticker := time.NewTicker(r.config.CacheTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.stopCh:
+			return nil
+		case cfg := <-r.reconfigureCh:
+			r.mu.Lock()
+			r.config = cfg
+			r.mu.Unlock()
+			ticker.Reset(cfg.CacheTTL / 2)
+		case <-ticker.C:
+			r.fetch(ctx)
+		}
+	}
+}
+
+// Stop stops polling
+func (r *remoteCluster) Stop() {
+	close(r.stopCh)
+}
+
+// Reconfigure hands an updated configuration to the polling loop
+func (r *remoteCluster) Reconfigure(cfg *types.Config) {
+	select {
+	case <-r.reconfigureCh:
+	default:
+	}
+	r.reconfigureCh <- cfg
+}
+
+// fetch requests the remote snapshot, keeping the previous one if it is
+// unchanged or cannot be fetched
+func (r *remoteCluster) fetch(ctx context.Context) {
+	r.mu.RLock()
+	etag := r.etag
+	r.mu.RUnlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		info := &types.ClusterInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+			r.failed(fmt.Errorf("failed to decode snapshot: %w", err))
+			return
+		}
+		r.mu.Lock()
+		r.info = info
+		r.etag = resp.Header.Get("ETag")
+		r.fetchedAt = time.Now()
+		r.lastError = ""
+		r.mu.Unlock()
+		r.logger.WithFields(logrus.Fields{
+			"nodes": len(info.Nodes),
+			"apps":  len(info.Apps),
+		}).Debug("Fetched remote snapshot")
+	case http.StatusNotModified:
+		r.mu.Lock()
+		r.fetchedAt = time.Now()
+		r.lastError = ""
+		r.mu.Unlock()
+	default:
+		var body struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &body) != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		r.failed(fmt.Errorf("reflector returned %s: %s", resp.Status, body.Error))
+	}
+}
+
+// failed records why the last fetch failed
+func (r *remoteCluster) failed(err error) {
+	r.logger.WithError(err).Warn("Failed to fetch remote snapshot")
+	r.mu.Lock()
+	r.lastError = err.Error()
+	r.mu.Unlock()
+}
+
+// GetClusterInfo returns the last fetched snapshot, with the same staleness
+// rules and errors as a locally discovered one
+func (r *remoteCluster) GetClusterInfo() (*types.ClusterInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.info == nil {
+		return &types.ClusterInfo{
+			APIVersion: "reflector.grid.sce.com/v1",
+			Timestamp:  time.Now(),
+			Nodes:      []types.Node{},
+			Apps:       []types.App{},
+			Stale:      true,
+			LastError:  r.lastError,
+		}, ErrNotReady
+	}
+
+	info := *r.info
+	info.Timestamp = time.Now()
+	if age := time.Since(r.fetchedAt); age > r.config.CacheTTL {
+		info.Stale = true
+		if r.lastError != "" {
+			info.LastError = r.lastError
+		}
+		if r.config.MaxStaleness > 0 && age > r.config.MaxStaleness {
+			return &info, ErrTooStale
+		}
+	}
+	return &info, nil
+}
@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"sort"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// sourceRecorder collects the outcome of each discovery source during one
+// cache rebuild
+type sourceRecorder struct {
+	checkedAt time.Time
+	statuses  map[string]*types.SourceStatus
+}
+
+// newSourceRecorder starts recording a cache rebuild
+func newSourceRecorder() *sourceRecorder {
+	return &sourceRecorder{
+		checkedAt: time.Now(),
+		statuses:  make(map[string]*types.SourceStatus),
+	}
+}
+
+// entry returns the status of a source, creating it on first use
+func (r *sourceRecorder) entry(kind, name string) *types.SourceStatus {
+	key := kind + "/" + name
+	status, ok := r.statuses[key]
+	if !ok {
+		status = &types.SourceStatus{
+			Name:      name,
+			Kind:      kind,
+			State:     types.SourceStateOK,
+			CheckedAt: r.checkedAt,
+		}
+		r.statuses[key] = status
+	}
+	return status
+}
+
+// record adds the outcome of discovering part of a source. Outcomes for the
+// same source accumulate, so a namespace covers every kind listed in it.
+func (r *sourceRecorder) record(kind, name string, duration time.Duration, items int, err error) {
+	status := r.entry(kind, name)
+	status.DurationSeconds += duration.Seconds()
+	status.Items += items
+	if err != nil {
+		status.State = types.SourceStateError
+		if status.Error != "" {
+			status.Error += "; "
+		}
+		status.Error += err.Error()
+	}
+}
+
+// failed reports whether any source failed
+func (r *sourceRecorder) failed() bool {
+	for _, status := range r.statuses {
+		if status.State == types.SourceStateError {
+			return true
+		}
+	}
+	return false
+}
+
+// skip records a source that was not discovered and why
+func (r *sourceRecorder) skip(kind, name, reason string) {
+	status := r.entry(kind, name)
+	status.State = types.SourceStateSkipped
+	status.Error = reason
+}
+
+// storeSources replaces the recorded statuses of the parts of the cache that
+// were rebuilt, keeping the previous statuses of the rest
+func (cd *ClusterDiscovery) storeSources(rec *sourceRecorder, nodes, apps bool) {
+	cd.cacheMutex.Lock()
+	defer cd.cacheMutex.Unlock()
+
+	sources := make(map[string]types.SourceStatus, len(rec.statuses)+len(cd.cache.Sources))
+	for key, status := range cd.cache.Sources {
+		if status.Kind == types.SourceKindNodes && !nodes || status.Kind != types.SourceKindNodes && !apps {
+			sources[key] = status
+		}
+	}
+	for key, status := range rec.statuses {
+		sources[key] = *status
+	}
+	cd.cache.Sources = sources
+}
+
+// sortedSources returns the cached source statuses ordered by kind and name.
+// The caller must hold cacheMutex.
+func (cd *ClusterDiscovery) sortedSources() []types.SourceStatus {
+	sources := make([]types.SourceStatus, 0, len(cd.cache.Sources))
+	for _, status := range cd.cache.Sources {
+		sources = append(sources, status)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Kind != sources[j].Kind {
+			return sources[i].Kind < sources[j].Kind
+		}
+		return sources[i].Name < sources[j].Name
+	})
+	return sources
+}
+
+// GetStatus reports the outcome of the last discovery of each source
+func (cd *ClusterDiscovery) GetStatus() *types.Status {
+	cd.cacheMutex.RLock()
+	defer cd.cacheMutex.RUnlock()
+
+	status := &types.Status{
+		Synced:    cd.synced.Load(),
+		Stale:     cd.cache.Data == nil || cd.cache.IsExpired(),
+		LastError: cd.cache.LastError,
+		Complete:  cd.cache.Data != nil,
+		Sources:   cd.sortedSources(),
+	}
+	if cd.cache.Data != nil {
+		lastRefreshed := cd.cache.UpdatedAt
+		status.LastRefreshed = &lastRefreshed
+	}
+	for _, source := range status.Sources {
+		if source.State == types.SourceStateError {
+			status.Complete = false
+		}
+	}
+
+	return status
+}
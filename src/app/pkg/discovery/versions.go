@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// placeholderVersions are reported when a workload has no usable version and
+// always sort below real versions
+var placeholderVersions = map[string]bool{
+	"":        true,
+	"unknown": true,
+	"latest":  true,
+}
+
+// compareVersions orders two versions, returning -1, 0 or 1. Semantic and
+// dotted numeric versions are compared numerically, anything else such as a
+// calendar-style 25r05 is compared with digit runs as numbers, and
+// placeholders like "unknown" sort lowest.
+func compareVersions(a, b string) int {
+	rankA, parsedA := versionRank(a)
+	rankB, parsedB := versionRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	if parsedA != nil && parsedB != nil {
+		if parsedA.LessThan(parsedB) {
+			return -1
+		}
+		if parsedB.LessThan(parsedA) {
+			return 1
+		}
+	}
+
+	// Equal so far, or neither parses: fall back to a natural order so that
+	// 1.2 and v1.2.0 still sort the same way every time
+	if c := compareNatural(a, b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// versionRank classifies a version as a placeholder (0), free-form (1) or
+// parseable (2), returning the parsed form for the latter
+func versionRank(v string) (int, *version.Version) {
+	if placeholderVersions[strings.ToLower(strings.TrimSpace(v))] {
+		return 0, nil
+	}
+	if parsed, err := version.ParseSemantic(v); err == nil {
+		return 2, parsed
+	}
+	if parsed, err := version.ParseGeneric(v); err == nil {
+		return 2, parsed
+	}
+	return 1, nil
+}
+
+// compareNatural compares strings with runs of digits compared as numbers
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		chunkA, restA := nextChunk(a)
+		chunkB, restB := nextChunk(b)
+
+		if isDigit(chunkA[0]) && isDigit(chunkB[0]) {
+			numA := strings.TrimLeft(chunkA, "0")
+			numB := strings.TrimLeft(chunkB, "0")
+			if len(numA) != len(numB) {
+				if len(numA) < len(numB) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(numA, numB); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(chunkA, chunkB); c != 0 {
+			return c
+		}
+
+		a, b = restA, restB
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// nextChunk splits off the leading run of digits or non-digits
+func nextChunk(s string) (string, string) {
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// sortApps orders apps by name and namespace, and the versions, replicas and
+// instances of each app so that responses are stable between requests
+func (cd *ClusterDiscovery) sortApps(apps []types.App) {
+	for i := range apps {
+		app := &apps[i]
+
+		// Newest version first
+		sort.SliceStable(app.Variants, func(x, y int) bool {
+			return compareVersions(app.Variants[x], app.Variants[y]) > 0
+		})
+		sort.SliceStable(app.Replicas, func(x, y int) bool {
+			return compareVersions(app.Replicas[x].Version, app.Replicas[y].Version) > 0
+		})
+		sort.SliceStable(app.Instances, func(x, y int) bool {
+			a, b := app.Instances[x], app.Instances[y]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return compareVersions(a.Version, b.Version) > 0
+		})
+
+		app.Version = cd.primaryVersion(app)
+	}
+
+	sort.SliceStable(apps, func(i, j int) bool {
+		if apps[i].Name != apps[j].Name {
+			return apps[i].Name < apps[j].Name
+		}
+		return apps[i].Namespace < apps[j].Namespace
+	})
+}
+
+// primaryVersion picks the version reported as an app's Version according
+// to the configured rule. Variants must already be sorted newest first.
+func (cd *ClusterDiscovery) primaryVersion(app *types.App) string {
+	if len(app.Variants) == 0 {
+		return app.Version
+	}
+	highest := app.Variants[0]
+
+	switch cd.config.PrimaryVersion {
+	case types.PrimaryVersionReplicas:
+		// Count running pods when known, otherwise the objects reporting each version
+		counts := make(map[string]int)
+		if len(app.Replicas) > 0 {
+			for _, r := range app.Replicas {
+				counts[r.Version] += r.Replicas
+			}
+		} else {
+			for _, instance := range app.Instances {
+				counts[instance.Version]++
+			}
+		}
+		best := highest
+		for _, v := range app.Variants {
+			if counts[v] > counts[best] {
+				best = v
+			}
+		}
+		return best
+
+	case types.PrimaryVersionCRD:
+		// The highest version declared by an AppVersion, if there is one
+		declared := ""
+		for _, instance := range app.Instances {
+			if instance.Source != types.AppSourceAppVersion {
+				continue
+			}
+			if declared == "" || compareVersions(instance.Version, declared) > 0 {
+				declared = instance.Version
+			}
+		}
+		if declared != "" {
+			return declared
+		}
+		return highest
+
+	default:
+		return highest
+	}
+}
@@ -0,0 +1,332 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultTemplatePath is where custom workload kinds keep their pod template
+const defaultTemplatePath = "spec.template"
+
+// workloadSource describes how to watch one workload kind and find its pod template
+type workloadSource struct {
+	// resource names the informer in logs and health output
+	resource string
+	// apiVersion is the group/version of the kind, for owner references
+	apiVersion string
+	// gvr is set for kinds served by a CRD, which are only watched if installed
+	gvr *schema.GroupVersionResource
+	// informer returns the shared informer for the kind
+	informer func(cd *ClusterDiscovery) cache.SharedIndexInformer
+	// podTemplate returns the pod template of a workload object
+	podTemplate func(obj interface{}) (*corev1.PodTemplateSpec, error)
+	// skipControlled ignores objects managed by another workload, such as
+	// ReplicaSets owned by a Deployment, which are reported by their owner
+	skipControlled bool
+}
+
+// builtinWorkloadSources are the workload kinds that can be enabled by name
+var builtinWorkloadSources = map[string]workloadSource{
+	"Deployment": {
+		resource:   "deployments",
+		apiVersion: "apps/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Apps().V1().Deployments().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*appsv1.Deployment).Spec.Template, nil
+		},
+	},
+	"StatefulSet": {
+		resource:   "statefulsets",
+		apiVersion: "apps/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Apps().V1().StatefulSets().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*appsv1.StatefulSet).Spec.Template, nil
+		},
+	},
+	"DaemonSet": {
+		resource:   "daemonsets",
+		apiVersion: "apps/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Apps().V1().DaemonSets().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*appsv1.DaemonSet).Spec.Template, nil
+		},
+	},
+	"ReplicaSet": {
+		resource:   "replicasets",
+		apiVersion: "apps/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Apps().V1().ReplicaSets().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*appsv1.ReplicaSet).Spec.Template, nil
+		},
+		skipControlled: true,
+	},
+	"Job": {
+		resource:   "jobs",
+		apiVersion: "batch/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Batch().V1().Jobs().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*batchv1.Job).Spec.Template, nil
+		},
+		skipControlled: true,
+	},
+	"CronJob": {
+		resource:   "cronjobs",
+		apiVersion: "batch/v1",
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.informerFactory.Batch().V1().CronJobs().Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			return &obj.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template, nil
+		},
+	},
+	"Rollout": dynamicWorkloadSource(schema.GroupVersionResource{
+		Group:    "argoproj.io",
+		Version:  "v1alpha1",
+		Resource: "rollouts",
+	}, defaultTemplatePath),
+}
+
+// dynamicWorkloadSource builds a source for a CRD-backed kind whose pod
+// template sits at a dotted path in the object
+func dynamicWorkloadSource(gvr schema.GroupVersionResource, templatePath string) workloadSource {
+	path := strings.Split(templatePath, ".")
+	return workloadSource{
+		resource:   gvr.GroupResource().String(),
+		apiVersion: gvr.GroupVersion().String(),
+		gvr:        &gvr,
+		informer: func(cd *ClusterDiscovery) cache.SharedIndexInformer {
+			return cd.dynamicFactory.ForResource(gvr).Informer()
+		},
+		podTemplate: func(obj interface{}) (*corev1.PodTemplateSpec, error) {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object type %T", obj)
+			}
+			raw, found, err := unstructured.NestedMap(u.Object, path...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", templatePath, err)
+			}
+			if !found {
+				return nil, fmt.Errorf("no pod template at %s", templatePath)
+			}
+			template := &corev1.PodTemplateSpec{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, template); err != nil {
+				return nil, fmt.Errorf("failed to convert pod template at %s: %w", templatePath, err)
+			}
+			return template, nil
+		},
+	}
+}
+
+// parseWorkloadResource parses a custom workload kind definition of the form
+// Kind=group/version/resource[:template.path]
+func parseWorkloadResource(def string) (string, workloadSource, error) {
+	kind, rest, ok := strings.Cut(strings.TrimSpace(def), "=")
+	if !ok || kind == "" {
+		return "", workloadSource{}, fmt.Errorf("invalid workload resource %q, expected Kind=group/version/resource[:template.path]", def)
+	}
+
+	gvrPart, templatePath, hasPath := strings.Cut(rest, ":")
+	if !hasPath || templatePath == "" {
+		templatePath = defaultTemplatePath
+	}
+
+	parts := strings.Split(gvrPart, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return "", workloadSource{}, fmt.Errorf("invalid workload resource %q, expected Kind=group/version/resource[:template.path]", def)
+	}
+
+	gvr := schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+	return kind, dynamicWorkloadSource(gvr, templatePath), nil
+}
+
+// buildWorkloadSources returns the builtin sources plus the custom kinds
+// defined in the configuration
+func buildWorkloadSources(cfg *types.Config) (map[string]workloadSource, error) {
+	sources := make(map[string]workloadSource, len(builtinWorkloadSources)+len(cfg.WorkloadResources))
+	for kind, source := range builtinWorkloadSources {
+		sources[kind] = source
+	}
+
+	for _, def := range cfg.WorkloadResources {
+		kind, source, err := parseWorkloadResource(def)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := sources[kind]; exists {
+			return nil, fmt.Errorf("workload resource %q redefines existing kind %s", def, kind)
+		}
+		sources[kind] = source
+	}
+
+	return sources, nil
+}
+
+// validateWorkloadKinds rejects workload kinds that have no source
+func validateWorkloadKinds(cfg *types.Config) error {
+	sources, err := buildWorkloadSources(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range cfg.WorkloadKinds {
+		if _, ok := sources[kind]; !ok {
+			known := make([]string, 0, len(sources))
+			for name := range sources {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown workload kind %q (known: %s)", kind, strings.Join(known, ", "))
+		}
+	}
+
+	return nil
+}
+
+// setupWorkloadInformers registers informers for configured workload kinds
+// that do not have one yet, returning the sync functions of the new ones
+func (cd *ClusterDiscovery) setupWorkloadInformers() ([]cache.InformerSynced, error) {
+	if !cd.config.AppDiscoveryEnabled || !cd.config.FallbackWorkloads || cd.config.CRDOnly {
+		return nil, nil
+	}
+
+	sources, err := buildWorkloadSources(cd.config)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []cache.InformerSynced
+	for _, kind := range cd.config.WorkloadKinds {
+		if _, exists := cd.workloadInformers[kind]; exists {
+			continue
+		}
+		source := sources[kind]
+
+		// CRD-backed kinds would never sync if the CRD is missing
+		if source.gvr != nil {
+			served, err := cd.resourceServed(*source.gvr)
+			if err != nil {
+				return nil, err
+			}
+			if !served {
+				cd.logger.WithFields(logrus.Fields{
+					"kind":     kind,
+					"resource": source.gvr.String(),
+				}).Warn("Workload resource is not served, skipping kind")
+				continue
+			}
+		}
+
+		informer := source.informer(cd)
+		if err := cd.addEventHandler(source.resource, informer, sourceApps); err != nil {
+			return nil, err
+		}
+		cd.workloadInformers[kind] = informer
+		added = append(added, informer.HasSynced)
+	}
+	cd.workloadSources = sources
+
+	podsSynced, err := cd.setupPodInformers()
+	if err != nil {
+		return nil, err
+	}
+	return append(added, podsSynced...), nil
+}
+
+// discoverFromWorkloadKind discovers apps from one workload kind's informer
+// cache. With a pod index, versions come from the workloads' running pods.
+func (cd *ClusterDiscovery) discoverFromWorkloadKind(kind string, namespaces []string, pods podIndex, appMap map[string]*types.App, rec *sourceRecorder) error {
+	informer, ok := cd.workloadInformers[kind]
+	if !ok {
+		rec.skip(types.SourceKindWorkload, kind, "workload kind is unknown or its resource is not served")
+		return nil
+	}
+	source := cd.workloadSources[kind]
+
+	// A namespace that fails to list does not keep the others from being
+	// discovered, the last error is returned
+	var failed error
+	for _, ns := range namespaces {
+		start := time.Now()
+		var objs []interface{}
+		appendObj := func(obj interface{}) {
+			objs = append(objs, obj)
+		}
+
+		var err error
+		if ns == "" {
+			// List from all namespaces
+			err = cache.ListAll(informer.GetIndexer(), labels.Everything(), appendObj)
+		} else {
+			// List from specific namespace
+			err = cache.ListAllByNamespace(informer.GetIndexer(), ns, labels.Everything(), appendObj)
+		}
+		if err != nil {
+			failed = fmt.Errorf("failed to list %s: %w", source.resource, err)
+			rec.record(types.SourceKindWorkload, kind, time.Since(start), 0, failed)
+			if ns != "" {
+				rec.record(types.SourceKindNamespace, ns, time.Since(start), 0, failed)
+			}
+			continue
+		}
+
+		for _, obj := range objs {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+			if source.skipControlled && metav1.GetControllerOf(accessor) != nil {
+				continue
+			}
+
+			template, err := source.podTemplate(obj)
+			if err != nil {
+				cd.logger.WithError(err).WithFields(logrus.Fields{
+					"kind":      kind,
+					"namespace": accessor.GetNamespace(),
+					"name":      accessor.GetName(),
+				}).Debug("Skipping workload without pod template")
+				continue
+			}
+
+			// Workloads without running pods still report their template version
+			if running := pods[workloadKey(kind, accessor.GetNamespace(), accessor.GetName())]; len(running) > 0 {
+				cd.processWorkloadPods(kind, accessor, template.Spec.Containers, running, appMap)
+				continue
+			}
+			cd.processWorkloadLabels(kind, accessor, template.Spec.Containers, appMap)
+		}
+
+		rec.record(types.SourceKindWorkload, kind, time.Since(start), len(objs), nil)
+		if ns != "" {
+			rec.record(types.SourceKindNamespace, ns, time.Since(start), len(objs), nil)
+		}
+	}
+
+	return failed
+}
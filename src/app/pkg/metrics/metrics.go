@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// namespace prefixes every metric name
+const namespace = "cluster_reflector"
+
+// Discovery sources used as the source label of discovery metrics
+const (
+	SourceNodes     = "nodes"
+	SourceCRD       = "crd"
+	SourceWorkloads = "workloads"
+)
+
+// Registry holds every metric exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// DiscoveryDuration times each discovery source during a cache rebuild
+	DiscoveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "discovery_duration_seconds",
+		Help:      "Time taken to discover each source from the informer caches",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"source"})
+
+	// RefreshErrors counts failed discoveries per source
+	RefreshErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_errors_total",
+		Help:      "Number of failed discoveries per source",
+	}, []string{"source"})
+
+	// HTTPRequestDuration times HTTP requests by route, method and status
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		snapshots,
+		DiscoveryDuration,
+		RefreshErrors,
+		HTTPRequestDuration,
+	)
+}
+
+// snapshots exports the last cluster snapshot
+var snapshots = &snapshotCollector{}
+
+// RecordSnapshot makes a new cluster snapshot the source of the per-app and
+// per-node series, so apps and nodes that went away stop being exported
+func RecordSnapshot(info *types.ClusterInfo) {
+	snapshots.info.Store(info)
+}
+
+var (
+	appInfoDesc = prometheus.NewDesc(namespace+"_app_info",
+		"Discovered application versions, one series per app, version, namespace and source",
+		[]string{"name", "version", "namespace", "source"}, nil)
+	nodeInfoDesc = prometheus.NewDesc(namespace+"_node_info",
+		"Cluster nodes with their role and kubelet version",
+		[]string{"name", "role", "kubelet_version"}, nil)
+	nodeRoleInfoDesc = prometheus.NewDesc(namespace+"_node_role_info",
+		"Roles of each node, one series per node and role",
+		[]string{"name", "role"}, nil)
+	nodesTotalDesc = prometheus.NewDesc(namespace+"_nodes_total",
+		"Total number of nodes in the cluster", nil, nil)
+	nodesByRoleDesc = prometheus.NewDesc(namespace+"_nodes_by_role",
+		"Number of nodes with each role, counting a node once for each of its roles",
+		[]string{"role"}, nil)
+	appsTotalDesc = prometheus.NewDesc(namespace+"_apps_total",
+		"Total number of discovered applications", nil, nil)
+	controlPlaneNodesDesc = prometheus.NewDesc(namespace+"_control_plane_nodes",
+		"Total number of control plane nodes", nil, nil)
+	workerNodesDesc = prometheus.NewDesc(namespace+"_worker_nodes",
+		"Total number of worker nodes", nil, nil)
+	cacheAgeDesc = prometheus.NewDesc(namespace+"_cache_age_seconds",
+		"Seconds since the cluster snapshot was last rebuilt", nil, nil)
+)
+
+// snapshotCollector builds the per-app and per-node series from the last
+// snapshot at scrape time, so a scrape never sees a half-updated set
+type snapshotCollector struct {
+	info atomic.Pointer[types.ClusterInfo]
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appInfoDesc
+	ch <- nodeInfoDesc
+	ch <- nodeRoleInfoDesc
+	ch <- nodesTotalDesc
+	ch <- nodesByRoleDesc
+	ch <- appsTotalDesc
+	ch <- controlPlaneNodesDesc
+	ch <- workerNodesDesc
+	ch <- cacheAgeDesc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	info := c.info.Load()
+	if info == nil {
+		return
+	}
+
+	// Several workloads can report the same app version in a namespace
+	seen := make(map[[4]string]bool)
+	for _, app := range info.Apps {
+		for _, instance := range app.Instances {
+			labels := [4]string{app.Name, instance.Version, instance.Namespace, instance.Source}
+			if seen[labels] {
+				continue
+			}
+			seen[labels] = true
+			ch <- prometheus.MustNewConstMetric(appInfoDesc, prometheus.GaugeValue, 1, labels[:]...)
+		}
+	}
+
+	controlPlane := 0
+	byRole := make(map[string]int)
+	for _, node := range info.Nodes {
+		ch <- prometheus.MustNewConstMetric(nodeInfoDesc, prometheus.GaugeValue, 1, node.Name, node.Role, node.Version)
+		if node.Role == types.NodeRoleControlPlane {
+			controlPlane++
+		}
+		for _, role := range node.Roles {
+			ch <- prometheus.MustNewConstMetric(nodeRoleInfoDesc, prometheus.GaugeValue, 1, node.Name, role)
+			byRole[role]++
+		}
+	}
+	for role, count := range byRole {
+		ch <- prometheus.MustNewConstMetric(nodesByRoleDesc, prometheus.GaugeValue, float64(count), role)
+	}
+
+	ch <- prometheus.MustNewConstMetric(nodesTotalDesc, prometheus.GaugeValue, float64(len(info.Nodes)))
+	ch <- prometheus.MustNewConstMetric(appsTotalDesc, prometheus.GaugeValue, float64(len(info.Apps)))
+	ch <- prometheus.MustNewConstMetric(controlPlaneNodesDesc, prometheus.GaugeValue, float64(controlPlane))
+	ch <- prometheus.MustNewConstMetric(workerNodesDesc, prometheus.GaugeValue, float64(len(info.Nodes)-controlPlane))
+	ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(info.Timestamp).Seconds())
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// notModified sets the ETag and Last-Modified headers of a response served
+// from a snapshot and writes a 304 if the client's copy is still current.
+// The ETag is weak as it leaves out the request timestamp, refresh time and
+// instance observation times, which change without the content changing.
+// Last-Modified likewise only advances when the content does.
+func (s *Server) notModified(w http.ResponseWriter, r *http.Request, info *types.ClusterInfo) bool {
+	etag := snapshotETag(r, info)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	var modified time.Time
+	if !info.ModifiedAt.IsZero() {
+		modified = info.ModifiedAt.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is only used when the client sent no ETag
+	current := false
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		current = etag != "" && etagMatches(match, etag)
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		current = err == nil && !modified.After(t)
+	}
+	if !current {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	s.logger.WithField("path", r.URL.Path).Debug("Snapshot not modified")
+	return true
+}
+
+// snapshotETag identifies a response by the snapshot content, the request
+// and the staleness metadata, or is empty before the first refresh
+func snapshotETag(r *http.Request, info *types.ClusterInfo) string {
+	if info.ContentHash == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", info.ContentHash, r.URL.Path, r.URL.Query().Encode())
+	fmt.Fprintf(h, "%t\n%s\n", info.Stale, info.LastError)
+	for _, source := range info.Sources {
+		fmt.Fprintf(h, "%s/%s=%s:%s\n", source.Kind, source.Name, source.State, source.Error)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists an ETag, using
+// the weak comparison
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/discovery"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// historyResponse is the /history payload
+type historyResponse struct {
+	Entries []types.HistoryEntry `json:"entries"`
+}
+
+// handleHistory handles GET /history
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := parseHistoryQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	entries, ok := s.discovery.GetHistory(query)
+	if !ok {
+		writeError(w, http.StatusNotFound, "disabled", fmt.Errorf("version history is disabled"))
+		return
+	}
+	if s.writeJSON(w, historyResponse{Entries: entries}) {
+		s.logger.WithField("entries", len(entries)).Debug("Served history")
+	}
+}
+
+// parseHistoryQuery validates the /history query parameters
+func parseHistoryQuery(values url.Values, now time.Time) (discovery.HistoryQuery, error) {
+	q := discovery.HistoryQuery{
+		Apps:       paramSet(values.Get("app")),
+		Nodes:      paramSet(values.Get("node")),
+		Namespaces: paramSet(values.Get("namespace")),
+	}
+
+	var err error
+	if q.Since, err = parseHistoryTime(values.Get("since"), now); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseHistoryTime(values.Get("until"), now); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit %q, must be a positive integer", limit)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// parseHistoryTime accepts an RFC 3339 time, or a duration meaning that long
+// before now
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", value)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/cluster-reflector/app/pkg/discovery"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// hubInfoResponse is the /cluster-info payload in hub mode
+type hubInfoResponse struct {
+	APIVersion string                        `json:"apiVersion"`
+	Timestamp  time.Time                     `json:"timestamp"`
+	Clusters   map[string]hubClusterResponse `json:"clusters"`
+}
+
+// hubClusterResponse is one cluster of a hub's /cluster-info, with its
+// filtered snapshot while it is healthy
+type hubClusterResponse struct {
+	types.MemberCluster
+	Cluster *types.ClusterIdentity `json:"cluster,omitempty"`
+	Nodes   *[]types.Node          `json:"nodes,omitempty"`
+	Apps    *[]types.App           `json:"apps,omitempty"`
+	Sources []types.SourceStatus   `json:"sources,omitempty"`
+}
+
+// hubAppListResponse is the /apps payload in hub mode. The clusters are
+// listed so that apps missing from an unhealthy cluster can be told apart
+// from apps that do not run there.
+type hubAppListResponse struct {
+	Apps     []types.HubApp        `json:"apps"`
+	Clusters []types.MemberCluster `json:"clusters"`
+}
+
+// NewHubServer creates an HTTP server for a hub, serving the aggregated
+// snapshots of its clusters
+func NewHubServer(cfg *types.Config, hub *discovery.Hub, logger *logrus.Logger) *Server {
+	s := &Server{
+		router: mux.NewRouter(),
+		hub:    hub,
+		config: cfg,
+		logger: logger,
+	}
+
+	s.setupRoutes()
+	return s
+}
+
+// setupHubRoutes configures the endpoints served in hub mode. Nodes, watch
+// and history are only served by the reflectors of each cluster.
+func (s *Server) setupHubRoutes() {
+	s.router.HandleFunc("/cluster-info", s.handleHubClusterInfo).Methods("GET")
+	s.router.HandleFunc("/healthz", s.handleHubHealthz).Methods("GET")
+	s.router.HandleFunc("/apps", s.handleHubApps).Methods("GET")
+	s.router.HandleFunc("/apps/{name}", s.handleHubApp).Methods("GET")
+	s.router.HandleFunc("/status", s.handleHubStatus).Methods("GET")
+}
+
+// parseHubQuery parses the query of a hub endpoint, which takes the filters
+// of the single cluster endpoint and cluster, but no paging. With a list,
+// include is fixed to that list as on /apps.
+func parseHubQuery(values url.Values, list string) (*clusterQuery, map[string]bool, error) {
+	if values.Get("limit") != "" || values.Get("continue") != "" {
+		return nil, nil, errors.New("limit and continue are not supported in hub mode")
+	}
+	clusters := paramSet(values.Get("cluster"))
+	rest := make(url.Values, len(values))
+	for key, value := range values {
+		if key != "cluster" {
+			rest[key] = value
+		}
+	}
+
+	var query *clusterQuery
+	var err error
+	if list != "" {
+		query, err = parseListQuery(rest, list)
+	} else {
+		query, err = parseClusterQuery(rest)
+	}
+	return query, clusters, err
+}
+
+// hubClusters returns the state of the clusters selected by a cluster filter
+func (s *Server) hubClusters(selected map[string]bool) ([]types.MemberCluster, error) {
+	all := s.hub.Clusters()
+	if selected == nil {
+		return all, nil
+	}
+
+	clusters := make([]types.MemberCluster, 0, len(selected))
+	known := make(map[string]bool, len(all))
+	for _, cluster := range all {
+		known[cluster.Name] = true
+		if selected[cluster.Name] {
+			clusters = append(clusters, cluster)
+		}
+	}
+	for name := range selected {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown cluster %q", name)
+		}
+	}
+	return clusters, nil
+}
+
+// handleHubClusterInfo handles GET /cluster-info in hub mode
+func (s *Server) handleHubClusterInfo(w http.ResponseWriter, r *http.Request) {
+	query, selected, err := parseHubQuery(r.URL.Query(), "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+	clusters, err := s.hubClusters(selected)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	resp := hubInfoResponse{
+		APIVersion: "reflector.grid.sce.com/v1",
+		Timestamp:  time.Now(),
+		Clusters:   make(map[string]hubClusterResponse, len(clusters)),
+	}
+	healthy := 0
+	for _, cluster := range clusters {
+		entry := hubClusterResponse{MemberCluster: cluster}
+		if cluster.Info != nil {
+			filtered := query.apply(cluster.Info)
+			entry.Nodes, entry.Apps, entry.Sources = filtered.Nodes, filtered.Apps, cluster.Info.Sources
+			entry.Cluster = cluster.Info.Cluster
+			healthy++
+		}
+		resp.Clusters[cluster.Name] = entry
+	}
+
+	if s.writeJSON(w, resp) {
+		s.logger.WithFields(logrus.Fields{
+			"clusters": len(clusters),
+			"healthy":  healthy,
+		}).Debug("Served hub cluster info")
+	}
+}
+
+// handleHubApps handles GET /apps in hub mode
+func (s *Server) handleHubApps(w http.ResponseWriter, r *http.Request) {
+	query, selected, err := parseHubQuery(r.URL.Query(), "apps")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+	clusters, err := s.hubClusters(selected)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	apps := discovery.AggregateApps(clusters, query.matchApp)
+	if s.writeJSON(w, hubAppListResponse{Apps: apps, Clusters: clusters}) {
+		s.logger.WithField("apps", len(apps)).Debug("Served hub apps")
+	}
+}
+
+// handleHubApp handles GET /apps/{name} in hub mode
+func (s *Server) handleHubApp(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query, selected, err := parseHubQuery(r.URL.Query(), "apps")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+	clusters, err := s.hubClusters(selected)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	matches := discovery.AggregateApps(clusters, func(app types.App) (types.App, bool) {
+		if app.Name != name {
+			return app, false
+		}
+		return query.matchApp(app)
+	})
+
+	switch len(matches) {
+	case 0:
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("app %q not found", name))
+	case 1:
+		s.writeJSON(w, matches[0])
+	default:
+		namespaces := make([]string, 0, len(matches))
+		for _, app := range matches {
+			namespaces = append(namespaces, app.Namespace)
+		}
+		writeError(w, http.StatusConflict, "ambiguous", fmt.Errorf(
+			"app %q is scoped to namespaces %s, select one with namespace", name, strings.Join(namespaces, ", ")))
+	}
+}
+
+// handleHubStatus handles GET /status in hub mode
+func (s *Server) handleHubStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, map[string]interface{}{
+		"clusters": s.hub.Clusters(),
+	})
+}
+
+// handleHubHealthz handles GET /healthz in hub mode, which is healthy while
+// any cluster is
+func (s *Server) handleHubHealthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.hub.HealthCheck(ctx); err != nil {
+		s.logger.WithError(err).Warn("Health check failed")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "unhealthy",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "healthy",
+	})
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// clusterQuery holds the query parameters accepted by /cluster-info
+type clusterQuery struct {
+	nodes         bool
+	apps          bool
+	appPrefixes   []string
+	namespaces    map[string]bool
+	roles         map[string]bool
+	labelSelector labels.Selector
+	limit         int
+	cursor        continueToken
+	filter        string
+}
+
+// continueToken is the opaque position handed back as continue. Positions
+// are the keys of the last items returned rather than offsets, so a page
+// never repeats or skips items that were already in the snapshot.
+type continueToken struct {
+	Filter string      `json:"f"`
+	Nodes  *listCursor `json:"n,omitempty"`
+	Apps   *listCursor `json:"a,omitempty"`
+}
+
+// listCursor is the position in one list of the snapshot
+type listCursor struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"ns,omitempty"`
+	Done      bool   `json:"done,omitempty"`
+}
+
+// clusterInfoResponse is the filtered /cluster-info payload. Lists left out
+// by include are nil and omitted, while an empty list is still encoded.
+type clusterInfoResponse struct {
+	*types.ClusterInfo
+	Nodes    *[]types.Node `json:"nodes,omitempty"`
+	Apps     *[]types.App  `json:"apps,omitempty"`
+	Continue string        `json:"continue,omitempty"`
+}
+
+// parseClusterQuery validates the /cluster-info query parameters
+func parseClusterQuery(values url.Values) (*clusterQuery, error) {
+	q := &clusterQuery{}
+
+	include := splitParam(values.Get("include"))
+	if len(include) == 0 {
+		q.nodes, q.apps = true, true
+	}
+	for _, part := range include {
+		switch part {
+		case "nodes":
+			q.nodes = true
+		case "apps":
+			q.apps = true
+		default:
+			return nil, fmt.Errorf("invalid include %q, must be nodes or apps", part)
+		}
+	}
+
+	q.appPrefixes = splitParam(values.Get("app"))
+	q.namespaces = paramSet(values.Get("namespace"))
+	q.roles = paramSet(values.Get("role"))
+
+	if selector := values.Get("labelSelector"); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labelSelector %q: %w", selector, err)
+		}
+		q.labelSelector = parsed
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q, must be a positive integer", limit)
+		}
+		q.limit = n
+	}
+
+	// A continue token only applies to the filters it was issued for
+	q.filter = filterHash(values)
+	if token := values.Get("continue"); token != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid continue token")
+		}
+		if err := json.Unmarshal(raw, &q.cursor); err != nil {
+			return nil, fmt.Errorf("invalid continue token")
+		}
+		if q.cursor.Filter != q.filter {
+			return nil, fmt.Errorf("continue token was issued for different filters")
+		}
+	}
+
+	return q, nil
+}
+
+// splitParam splits a comma-separated parameter, dropping empty parts
+func splitParam(value string) []string {
+	var parts []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// paramSet returns the parts of a comma-separated parameter as a set, or nil
+// if the parameter is empty
+func paramSet(value string) map[string]bool {
+	parts := splitParam(value)
+	if len(parts) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		set[part] = true
+	}
+	return set
+}
+
+// filterHash identifies the filters of a query, leaving out the paging
+// parameters so every page of a listing shares it
+func filterHash(values url.Values) string {
+	h := fnv.New64a()
+	for _, key := range []string{"include", "app", "namespace", "role", "labelSelector"} {
+		fmt.Fprintf(h, "%s=%s\n", key, values.Get(key))
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// apply filters and pages a snapshot. The snapshot's slices are shared with
+// the cache, so filtered items are copied rather than modified in place.
+func (q *clusterQuery) apply(info *types.ClusterInfo) *clusterInfoResponse {
+	resp := &clusterInfoResponse{ClusterInfo: info}
+	next := continueToken{Filter: q.filter}
+	more := false
+
+	if q.nodes {
+		nodes := make([]types.Node, 0, len(info.Nodes))
+		for _, node := range info.Nodes {
+			if q.matchNode(node) {
+				nodes = append(nodes, node)
+			}
+		}
+		nodes, next.Nodes = pageList(nodes, q.cursor.Nodes, q.limit, func(n types.Node) listCursor {
+			return listCursor{Name: n.Name}
+		})
+		more = more || !next.Nodes.Done
+		resp.Nodes = &nodes
+	}
+
+	if q.apps {
+		apps := make([]types.App, 0, len(info.Apps))
+		for _, app := range info.Apps {
+			if filtered, ok := q.matchApp(app); ok {
+				apps = append(apps, filtered)
+			}
+		}
+		apps, next.Apps = pageList(apps, q.cursor.Apps, q.limit, func(a types.App) listCursor {
+			return listCursor{Name: a.Name, Namespace: a.Namespace}
+		})
+		more = more || !next.Apps.Done
+		resp.Apps = &apps
+	}
+
+	if more {
+		raw, _ := json.Marshal(next)
+		resp.Continue = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return resp
+}
+
+// matchNode reports whether a node passes the role and label filters. The
+// role filter matches any of a node's roles, not only the primary one.
+func (q *clusterQuery) matchNode(node types.Node) bool {
+	if q.roles != nil {
+		matched := q.roles[node.Role]
+		for _, role := range node.Roles {
+			matched = matched || q.roles[role]
+		}
+		if !matched {
+			return false
+		}
+	}
+	if q.labelSelector != nil && !q.labelSelector.Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	return true
+}
+
+// matchApp reports whether an app passes the name and namespace filters.
+// With a namespace filter, a cluster-scoped app is narrowed to its instances
+// in those namespaces and the versions they report.
+func (q *clusterQuery) matchApp(app types.App) (types.App, bool) {
+	if len(q.appPrefixes) > 0 {
+		matched := false
+		for _, prefix := range q.appPrefixes {
+			if strings.HasPrefix(app.Name, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return app, false
+		}
+	}
+
+	if q.namespaces == nil {
+		return app, true
+	}
+	if app.Scope == types.AppScopeNamespace {
+		return app, q.namespaces[app.Namespace]
+	}
+
+	versions := make(map[string]bool)
+	instances := make([]types.AppInstance, 0, len(app.Instances))
+	for _, instance := range app.Instances {
+		if q.namespaces[instance.Namespace] {
+			instances = append(instances, instance)
+			versions[instance.Version] = true
+		}
+	}
+	if len(instances) == 0 {
+		return app, false
+	}
+
+	// Variants and replicas keep their order, newest first, and replicas
+	// are recounted from the instances kept
+	variants := make([]string, 0, len(versions))
+	for _, v := range app.Variants {
+		if versions[v] {
+			variants = append(variants, v)
+		}
+	}
+	var replicas []types.VersionReplicas
+	for _, r := range app.Replicas {
+		if !versions[r.Version] {
+			continue
+		}
+		counted := types.VersionReplicas{Version: r.Version}
+		for _, instance := range instances {
+			if instance.Version == r.Version {
+				counted.Replicas += instance.Replicas
+				counted.Ready += instance.Ready
+			}
+		}
+		if counted.Replicas > 0 {
+			replicas = append(replicas, counted)
+		}
+	}
+
+	app.Instances = instances
+	app.Variants = variants
+	app.Replicas = replicas
+	if !versions[app.Version] && len(variants) > 0 {
+		app.Version = variants[0]
+	}
+	return app, true
+}
+
+// pageList returns the items after a cursor, at most limit of them when
+// limit is set, and the cursor to continue from. Items must be sorted by the
+// cursor key.
+func pageList[T any](items []T, cursor *listCursor, limit int, key func(T) listCursor) ([]T, *listCursor) {
+	if cursor != nil && cursor.Done {
+		return items[:0], cursor
+	}
+
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			k := key(items[i])
+			return k.Name > cursor.Name || k.Name == cursor.Name && k.Namespace > cursor.Namespace
+		})
+	}
+	items = items[start:]
+
+	if limit == 0 || len(items) <= limit {
+		return items, &listCursor{Done: true}
+	}
+	last := key(items[limit-1])
+	return items[:limit], &last
+}
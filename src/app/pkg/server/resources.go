@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/yourorg/cluster-reflector/app/pkg/types"
+)
+
+// appListResponse is the /apps payload
+type appListResponse struct {
+	Apps     []types.App `json:"apps"`
+	Continue string      `json:"continue,omitempty"`
+}
+
+// nodeListResponse is the /nodes payload
+type nodeListResponse struct {
+	Nodes    []types.Node `json:"nodes"`
+	Continue string       `json:"continue,omitempty"`
+}
+
+// parseListQuery parses the query of a single list resource, which takes the
+// /cluster-info filters with include fixed to that list
+func parseListQuery(values url.Values, list string) (*clusterQuery, error) {
+	if values.Get("include") != "" {
+		return nil, fmt.Errorf("include is not supported on /%s", list)
+	}
+	fixed := make(url.Values, len(values)+1)
+	for key, value := range values {
+		fixed[key] = value
+	}
+	fixed.Set("include", list)
+	return parseClusterQuery(fixed)
+}
+
+// handleApps handles GET /apps
+func (s *Server) handleApps(w http.ResponseWriter, r *http.Request) {
+	query, err := parseListQuery(r.URL.Query(), "apps")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	info, ok := s.snapshot(w)
+	if !ok || s.notModified(w, r, info) {
+		return
+	}
+
+	resp := query.apply(info)
+	if s.writeJSON(w, appListResponse{Apps: *resp.Apps, Continue: resp.Continue}) {
+		s.logger.WithField("apps", len(*resp.Apps)).Debug("Served apps")
+	}
+}
+
+// handleApp handles GET /apps/{name}. A namespace filter narrows the app as on
+// /cluster-info, and picks one of several namespace-scoped apps of that name.
+func (s *Server) handleApp(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query, err := parseListQuery(r.URL.Query(), "apps")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	info, ok := s.snapshot(w)
+	if !ok || s.notModified(w, r, info) {
+		return
+	}
+
+	var matches []types.App
+	for _, app := range info.Apps {
+		if app.Name != name {
+			continue
+		}
+		if filtered, ok := query.matchApp(app); ok {
+			matches = append(matches, filtered)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("app %q not found", name))
+	case 1:
+		s.writeJSON(w, matches[0])
+	default:
+		namespaces := make([]string, 0, len(matches))
+		for _, app := range matches {
+			namespaces = append(namespaces, app.Namespace)
+		}
+		writeError(w, http.StatusConflict, "ambiguous", fmt.Errorf(
+			"app %q is scoped to namespaces %s, select one with namespace", name, strings.Join(namespaces, ", ")))
+	}
+}
+
+// handleNodes handles GET /nodes
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	query, err := parseListQuery(r.URL.Query(), "nodes")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	info, ok := s.snapshot(w)
+	if !ok || s.notModified(w, r, info) {
+		return
+	}
+
+	resp := query.apply(info)
+	if s.writeJSON(w, nodeListResponse{Nodes: *resp.Nodes, Continue: resp.Continue}) {
+		s.logger.WithField("nodes", len(*resp.Nodes)).Debug("Served nodes")
+	}
+}
+
+// handleNode handles GET /nodes/{name}
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	info, ok := s.snapshot(w)
+	if !ok || s.notModified(w, r, info) {
+		return
+	}
+
+	for _, node := range info.Nodes {
+		if node.Name == name {
+			s.writeJSON(w, node)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("node %q not found", name))
+}
@@ -2,21 +2,30 @@ package server
 
 import (
 	"context"
+	_ "embed"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/yourorg/cluster-reflector/app/pkg/discovery"
+	"github.com/yourorg/cluster-reflector/app/pkg/metrics"
 	"github.com/yourorg/cluster-reflector/app/pkg/types"
 )
 
+// openAPISpec documents the HTTP API
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
 // Server represents the HTTP server
 type Server struct {
 	router    *mux.Router
 	discovery *discovery.ClusterDiscovery
+	hub       *discovery.Hub // Set instead of discovery in hub mode
 	config    *types.Config
 	logger    *logrus.Logger
 	server    *http.Server
@@ -38,17 +47,31 @@ func NewServer(cfg *types.Config, discovery *discovery.ClusterDiscovery, logger
 // setupRoutes configures the HTTP routes
 func (s *Server) setupRoutes() {
 	// Main endpoints
-	s.router.HandleFunc("/cluster-info", s.handleClusterInfo).Methods("GET")
-	s.router.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	if s.hub != nil {
+		s.setupHubRoutes()
+	} else {
+		s.router.HandleFunc("/cluster-info", s.handleClusterInfo).Methods("GET")
+		s.router.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+		s.router.HandleFunc("/apps", s.handleApps).Methods("GET")
+		s.router.HandleFunc("/apps/{name}", s.handleApp).Methods("GET")
+		s.router.HandleFunc("/nodes", s.handleNodes).Methods("GET")
+		s.router.HandleFunc("/nodes/{name}", s.handleNode).Methods("GET")
+		s.router.HandleFunc("/watch", s.handleWatch).Methods("GET")
+		s.router.HandleFunc("/history", s.handleHistory).Methods("GET")
+		s.router.HandleFunc("/status", s.handleStatus).Methods("GET")
+	}
+	s.router.HandleFunc("/openapi.yaml", s.handleOpenAPI).Methods("GET")
 	
 	// Optional metrics endpoint
 	if s.config.MetricsEnabled {
-		s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+		s.router.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})).Methods("GET")
 	}
 
 	// Middleware
 	s.router.Use(s.loggingMiddleware)
+	s.router.Use(s.metricsMiddleware)
 	s.router.Use(s.corsMiddleware)
+	s.router.Use(s.compressionMiddleware)
 }
 
 // Start starts the HTTP server
@@ -83,20 +106,98 @@ func (s *Server) Start(ctx context.Context) error {
 
 // handleClusterInfo handles GET /cluster-info
 func (s *Server) handleClusterInfo(w http.ResponseWriter, r *http.Request) {
-	info := s.discovery.GetClusterInfo()
-	
+	query, err := parseClusterQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	info, ok := s.snapshot(w)
+	if !ok || s.notModified(w, r, info) {
+		return
+	}
+
+	resp := query.apply(info)
+	if !s.writeJSON(w, resp) {
+		return
+	}
+
+	fields := logrus.Fields{}
+	if resp.Nodes != nil {
+		fields["nodes"] = len(*resp.Nodes)
+	}
+	if resp.Apps != nil {
+		fields["apps"] = len(*resp.Apps)
+	}
+	s.logger.WithFields(fields).Debug("Served cluster info")
+}
+
+// snapshot returns the cluster snapshot to serve and sets the staleness
+// headers. If the snapshot must not be served it writes a 503 and returns false.
+func (s *Server) snapshot(w http.ResponseWriter) (*types.ClusterInfo, bool) {
+	info, err := s.discovery.GetClusterInfo()
+	setStalenessHeaders(w, info)
+
+	if err != nil {
+		s.logger.WithError(err).Warn("Refusing to serve cluster info")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        "unavailable",
+			"error":         err.Error(),
+			"lastRefreshed": info.LastRefreshed,
+			"lastError":     info.LastError,
+		})
+		return nil, false
+	}
+	return info, true
+}
+
+// writeJSON encodes a successful response, reporting whether it was written
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) bool {
 	w.Header().Set("Content-Type", "application/json")
-	
-	if err := json.NewEncoder(w).Encode(info); err != nil {
-		s.logger.WithError(err).Error("Failed to encode cluster info")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.WithError(err).Error("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, code int, status string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"error":  err.Error(),
+	})
+}
+
+// setStalenessHeaders mirrors the snapshot's staleness metadata in headers
+func setStalenessHeaders(w http.ResponseWriter, info *types.ClusterInfo) {
+	w.Header().Set("X-Reflector-Stale", strconv.FormatBool(info.Stale))
+	if info.LastRefreshed != nil {
+		w.Header().Set("X-Reflector-Last-Refreshed", info.LastRefreshed.UTC().Format(time.RFC3339))
+	}
+	if info.LastError != "" {
+		w.Header().Set("X-Reflector-Last-Error", info.LastError)
+	}
+}
+
+// handleStatus handles GET /status
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.discovery.GetStatus()); err != nil {
+		s.logger.WithError(err).Error("Failed to encode discovery status")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
 	}
+}
 
-	s.logger.WithFields(logrus.Fields{
-		"nodes": len(info.Nodes),
-		"apps":  len(info.Apps),
-	}).Debug("Served cluster info")
+// handleOpenAPI handles GET /openapi.yaml
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
 }
 
 // handleHealthz handles GET /healthz
@@ -122,40 +223,6 @@ func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleMetrics handles GET /metrics (basic implementation)
-func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	info := s.discovery.GetClusterInfo()
-	
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	
-	fmt.Fprintf(w, "# HELP cluster_reflector_nodes_total Total number of nodes in the cluster\n")
-	fmt.Fprintf(w, "# TYPE cluster_reflector_nodes_total gauge\n")
-	fmt.Fprintf(w, "cluster_reflector_nodes_total %d\n", len(info.Nodes))
-	
-	fmt.Fprintf(w, "# HELP cluster_reflector_apps_total Total number of discovered applications\n")
-	fmt.Fprintf(w, "# TYPE cluster_reflector_apps_total gauge\n")
-	fmt.Fprintf(w, "cluster_reflector_apps_total %d\n", len(info.Apps))
-	
-	// Count control plane vs worker nodes
-	controlPlaneNodes := 0
-	workerNodes := 0
-	for _, node := range info.Nodes {
-		if node.Role == "control-plane" {
-			controlPlaneNodes++
-		} else {
-			workerNodes++
-		}
-	}
-	
-	fmt.Fprintf(w, "# HELP cluster_reflector_control_plane_nodes Total number of control plane nodes\n")
-	fmt.Fprintf(w, "# TYPE cluster_reflector_control_plane_nodes gauge\n")
-	fmt.Fprintf(w, "cluster_reflector_control_plane_nodes %d\n", controlPlaneNodes)
-	
-	fmt.Fprintf(w, "# HELP cluster_reflector_worker_nodes Total number of worker nodes\n")
-	fmt.Fprintf(w, "# TYPE cluster_reflector_worker_nodes gauge\n")
-	fmt.Fprintf(w, "cluster_reflector_worker_nodes %d\n", workerNodes)
-}
-
 // loggingMiddleware logs HTTP requests
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -179,12 +246,35 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// metricsMiddleware records request latency by route, method and status
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rr := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rr, r)
+
+		// Label by route template rather than path to keep cardinality bounded
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		metrics.HTTPRequestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(rr.statusCode)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
 // corsMiddleware adds CORS headers
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, If-Modified-Since, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Reflector-Stale, X-Reflector-Last-Refreshed, X-Reflector-Last-Error")
 		
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -205,3 +295,8 @@ func (rr *responseRecorder) WriteHeader(code int) {
 	rr.statusCode = code
 	rr.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
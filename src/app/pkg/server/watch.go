@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchHeartbeat is how often an idle stream gets a comment, so proxies and
+// clients can tell it is still open
+const watchHeartbeat = 15 * time.Second
+
+// handleWatch handles GET /watch, streaming change events as Server-Sent
+// Events. A new client first gets the full snapshot as a snapshot event. A
+// client resuming with Last-Event-ID gets the events it missed instead, or
+// a new snapshot if they are no longer kept.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+
+	info, ok := s.snapshot(w)
+	if !ok {
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.WithError(err).Debug("Cannot lift the write deadline of the watch stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	epoch := s.discovery.ChangeEpoch()
+	id, resumed := parseEventID(lastID, epoch)
+	if !resumed {
+		if err := writeEvent(w, "snapshot", eventID(epoch, info.EventID), info); err != nil {
+			return
+		}
+		id = info.EventID
+	}
+	s.logger.WithField("resumed", resumed).Debug("Watch stream opened")
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		events, changed, ok := s.discovery.ChangesSince(id)
+		if !ok {
+			// Fell behind the change log, start over from the snapshot
+			info, err := s.discovery.GetClusterInfo()
+			if err != nil {
+				return
+			}
+			if err := writeEvent(w, "snapshot", eventID(epoch, info.EventID), info); err != nil {
+				return
+			}
+			id = info.EventID
+			continue
+		}
+		for _, event := range events {
+			if err := writeEvent(w, event.Type, eventID(epoch, event.ID), event); err != nil {
+				return
+			}
+			id = event.ID
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			s.logger.Debug("Watch stream closed")
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes one Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, event, id string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, payload)
+	return err
+}
+
+// eventID formats an event ID with the epoch of the change log it is from
+func eventID(epoch string, id uint64) string {
+	return epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// parseEventID returns the position of a Last-Event-ID in the current change
+// log, or false if it is missing or from another epoch
+func parseEventID(value, epoch string) (uint64, bool) {
+	prefix, id, ok := strings.Cut(value, "-")
+	if !ok || prefix != epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
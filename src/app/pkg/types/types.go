@@ -12,21 +12,330 @@ type ClusterInfo struct {
 	Timestamp  time.Time `json:"timestamp"`
 	Nodes      []Node    `json:"nodes"`
 	Apps       []App     `json:"apps"`
+	// Stale is set when the snapshot has not been refreshed within the cache TTL
+	Stale         bool       `json:"stale"`
+	LastRefreshed *time.Time `json:"lastRefreshed,omitempty"`
+	// LastError is why the last refresh failed, while the snapshot is stale
+	LastError string `json:"lastError,omitempty"`
+	// Sources is the outcome of each discovery source, if embedding is enabled
+	Sources []SourceStatus `json:"sources,omitempty"`
+	// Cluster identifies the cluster the snapshot was taken from
+	Cluster *ClusterIdentity `json:"cluster,omitempty"`
+	// ContentHash identifies the nodes and apps of the snapshot, unset before
+	// the first refresh
+	ContentHash string `json:"-"`
+	// ModifiedAt is when the content hash last changed
+	ModifiedAt time.Time `json:"-"`
+	// EventID is the last change event recorded when the snapshot was taken
+	EventID uint64 `json:"-"`
+}
+
+// ClusterIdentity identifies a cluster and its platform
+type ClusterIdentity struct {
+	// Name is the configured cluster name
+	Name string `json:"name,omitempty"`
+	// ID is the UID of the kube-system namespace, stable for the cluster's lifetime
+	ID string `json:"id,omitempty"`
+	// KubernetesVersion is the API server's git version
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// Distribution is detected from the API server version, API groups and nodes
+	Distribution string `json:"distribution"`
+	// APIGroups lists the installed API groups of interest
+	APIGroups []string `json:"apiGroups,omitempty"`
+}
+
+// Kubernetes distributions reported in ClusterIdentity
+const (
+	DistributionK3s       = "k3s"
+	DistributionEKS       = "eks"
+	DistributionAKS       = "aks"
+	DistributionGKE       = "gke"
+	DistributionOpenShift = "openshift"
+	DistributionKubeadm   = "kubeadm"
+	DistributionUnknown   = "unknown"
+)
+
+// Change event types, computed by diffing successive snapshots
+const (
+	ChangeAppAdded           = "app-added"
+	ChangeAppRemoved         = "app-removed"
+	ChangeAppVersionChanged  = "app-version-changed"
+	ChangeVariantAdded       = "variant-added"
+	ChangeVariantRemoved     = "variant-removed"
+	ChangeNodeAdded          = "node-added"
+	ChangeNodeRemoved        = "node-removed"
+	ChangeNodeRoleChanged    = "node-role-changed"
+	ChangeNodeVersionChanged = "node-version-changed"
+)
+
+// ChangeEvent is one change between successive snapshots
+type ChangeEvent struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// App and Namespace are set for app events, Namespace only for
+	// namespace-scoped apps
+	App       string `json:"app,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	// Node is set for node events
+	Node string `json:"node,omitempty"`
+	// Version and Role are the new values, Previous* the replaced ones
+	Version         string `json:"version,omitempty"`
+	PreviousVersion string `json:"previousVersion,omitempty"`
+	Role            string `json:"role,omitempty"`
+	PreviousRole    string `json:"previousRole,omitempty"`
+}
+
+// Status is the discovery status served on /status
+type Status struct {
+	Synced        bool       `json:"synced"`
+	Stale         bool       `json:"stale"`
+	LastRefreshed *time.Time `json:"lastRefreshed,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	// Complete is false while any source failed, so the apps may be incomplete
+	Complete bool           `json:"complete"`
+	Sources  []SourceStatus `json:"sources"`
+}
+
+// Member cluster sources, how a hub reaches each cluster it aggregates
+const (
+	MemberSourceKubeconfig = "kubeconfig"
+	MemberSourceRemote     = "remote"
+)
+
+// MemberCluster is the state of one cluster aggregated in hub mode
+type MemberCluster struct {
+	Name string `json:"name"`
+	// Source is kubeconfig or remote, and Endpoint the context or URL
+	Source   string `json:"source"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Healthy is false while the cluster's snapshot cannot be served
+	Healthy       bool       `json:"healthy"`
+	Stale         bool       `json:"stale"`
+	LastRefreshed *time.Time `json:"lastRefreshed,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	// Info is the cluster's snapshot, nil while it is unhealthy
+	Info *ClusterInfo `json:"-"`
+}
+
+// HubApp is an app across the member clusters of a hub
+type HubApp struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Versions lists the versions the clusters report, highest first
+	Versions []string `json:"versions"`
+	// Clusters maps the name of each cluster running the app to its version there
+	Clusters map[string]HubAppPlacement `json:"clusters"`
+}
+
+// HubAppPlacement is an app's version in one member cluster
+type HubAppPlacement struct {
+	Version  string            `json:"version"`
+	Variants []string          `json:"variants"`
+	Replicas []VersionReplicas `json:"replicas,omitempty"`
+}
+
+// Source kinds, grouping the sources reported in SourceStatus
+const (
+	SourceKindNodes     = "nodes"
+	SourceKindCRD       = "crd"
+	SourceKindWorkload  = "workload"
+	SourceKindPods      = "pods"
+	SourceKindNamespace = "namespace"
+)
+
+// Source states
+const (
+	SourceStateOK      = "ok"
+	SourceStateError   = "error"
+	SourceStateSkipped = "skipped"
+)
+
+// SourceStatus is the outcome of the last discovery of one source
+type SourceStatus struct {
+	Name            string    `json:"name"`
+	Kind            string    `json:"kind"`
+	State           string    `json:"state"`
+	Error           string    `json:"error,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Items           int       `json:"items"`
+	CheckedAt       time.Time `json:"checkedAt"`
 }
 
 // Node represents a cluster node
 type Node struct {
-	Name    string `json:"name"`
-	IP      string `json:"ip"`
-	Role    string `json:"role"`
-	Version string `json:"version"`
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	Role string `json:"role"`
+	// Roles lists every role of the node, from its node-role labels and the
+	// role rules it matches, including the primary Role
+	Roles   []string `json:"roles"`
+	Version string   `json:"version"`
+	// Addresses lists every address of the node, IP is the preferred one
+	Addresses []NodeAddress `json:"addresses"`
+	// Details are reported with node details enabled
+	Details *NodeDetails `json:"details,omitempty"`
+	// Labels are only kept for filtering by label selector
+	Labels map[string]string `json:"-"`
+}
+
+// NodeAddress is one address of a node, such as an InternalIP or Hostname
+type NodeAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	// Family is IPv4 or IPv6 for IP addresses, empty for names
+	Family string `json:"family,omitempty"`
+}
+
+// IP address families of node addresses
+const (
+	AddressFamilyIPv4 = "IPv4"
+	AddressFamilyIPv6 = "IPv6"
+)
+
+// Primary node roles assigned by default
+const (
+	NodeRoleControlPlane = "control-plane"
+	NodeRoleWorker       = "worker"
+)
+
+// NodeDetails describes a node's health, resources, platform and scheduling
+type NodeDetails struct {
+	Ready bool `json:"ready"`
+	// Conditions lists the node conditions, without their heartbeat times
+	Conditions  []NodeCondition `json:"conditions"`
+	Capacity    NodeResources   `json:"capacity"`
+	Allocatable NodeResources   `json:"allocatable"`
+
+	OSImage          string `json:"osImage,omitempty"`
+	KernelVersion    string `json:"kernelVersion,omitempty"`
+	ContainerRuntime string `json:"containerRuntime,omitempty"`
+	OperatingSystem  string `json:"operatingSystem,omitempty"`
+	Architecture     string `json:"architecture,omitempty"`
+
+	// Zone and Region come from the topology labels
+	Zone   string `json:"zone,omitempty"`
+	Region string `json:"region,omitempty"`
+
+	Unschedulable bool        `json:"unschedulable"`
+	Taints        []NodeTaint `json:"taints,omitempty"`
+}
+
+// NodeCondition is the state of one node condition, such as Ready or
+// MemoryPressure
+type NodeCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NodeResources are the CPU, memory and pod counts of a node as quantities
+type NodeResources struct {
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Pods   string `json:"pods,omitempty"`
+}
+
+// NodeTaint is a taint keeping pods off a node
+type NodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
 }
 
 // App represents an application with version information
 type App struct {
-	Name     string   `json:"name"`
-	Version  string   `json:"version"`
-	Variants []string `json:"variants"`
+	Name string `json:"name"`
+	// Namespace is set when apps are scoped per namespace
+	Namespace string   `json:"namespace,omitempty"`
+	Scope     string   `json:"scope"`
+	Version   string   `json:"version"`
+	Variants  []string `json:"variants"`
+	// Replicas counts running pods per version, set in pod version mode
+	Replicas []VersionReplicas `json:"replicas,omitempty"`
+	// Instances lists every object the app was discovered from
+	Instances []AppInstance `json:"instances"`
+}
+
+// App scopes, deciding whether apps with the same name in different
+// namespaces are reported as one app or separately
+const (
+	AppScopeCluster   = "cluster"
+	AppScopeNamespace = "namespace"
+)
+
+// Primary version rules, deciding which variant is reported as an app's Version
+const (
+	PrimaryVersionHighest  = "highest"  // the highest version
+	PrimaryVersionReplicas = "replicas" // the version with the most running pods
+	PrimaryVersionCRD      = "crd"      // the version declared by an AppVersion, else the highest
+)
+
+// Image name modes, deciding which part of an image reference names an app
+// discovered from its image
+const (
+	ImageNameLast       = "last"       // the last path segment, app in registry/team/app
+	ImageNameRepository = "repository" // the repository without registry, team/app
+	ImageNameRegex      = "regex"      // a capture group of ImageNamePattern
+)
+
+// App sources, recording how an app instance was discovered
+const (
+	AppSourceAppVersion  = "appversion"
+	AppSourceLabels      = "workload-labels"
+	AppSourceAnnotations = "workload-annotations"
+	AppSourceImageTag    = "image-tag"
+	AppSourcePods        = "pods"
+)
+
+// AppInstance is one object an app version was discovered from
+type AppInstance struct {
+	Namespace  string    `json:"namespace"`
+	Source     string    `json:"source"`
+	Rule       string    `json:"rule,omitempty"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	Image      string    `json:"image,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	ObservedAt time.Time `json:"observedAt"`
+	// Replicas and Ready count the workload's running pods on this
+	// version, with --pod-versions
+	Replicas int `json:"replicas,omitempty"`
+	Ready    int `json:"ready,omitempty"`
+}
+
+// VersionReplicas counts the running pods of one app version
+type VersionReplicas struct {
+	Version  string `json:"version"`
+	Replicas int    `json:"replicas"`
+	Ready    int    `json:"ready"`
+}
+
+// History stores, deciding where the version history is persisted
+const (
+	HistoryStoreMemory    = "memory"
+	HistoryStoreFile      = "file"
+	HistoryStoreConfigMap = "configmap"
+)
+
+// HistoryEntry is one version transition of an app instance or a node
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	// App or Node is the subject of the transition
+	App  string `json:"app,omitempty"`
+	Node string `json:"node,omitempty"`
+	// Namespace, Kind and Name identify the object the version was found on
+	Namespace string `json:"namespace,omitempty"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Source    string `json:"source,omitempty"`
+	// From and To are the versions before and after, empty when the object
+	// appeared or disappeared. Objects running several versions at once,
+	// such as during a rollout, list them comma-separated.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
 }
 
 // AppVersion is our custom CRD structure
@@ -52,8 +361,20 @@ type AppVersionSpec struct {
 type AppVersionStatus struct {
 	// ObservedAt is the timestamp when this version was last observed
 	ObservedAt *metav1.Time `json:"observedAt,omitempty"`
+	// MatchedWorkloads counts the workloads discovered for the same app
+	MatchedWorkloads int `json:"matchedWorkloads"`
+	// Conditions report whether the declared version is running
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// AppVersion condition types
+const (
+	// AppVersionVerified is True when a workload runs the declared version
+	AppVersionVerified = "Verified"
+	// AppVersionDrift is True when a workload runs another version
+	AppVersionDrift = "Drift"
+)
+
 // AppVersionList contains a list of AppVersion
 // +kubebuilder:object:root=true
 type AppVersionList struct {
@@ -66,21 +387,96 @@ type AppVersionList struct {
 type Config struct {
 	Listen              string
 	CacheTTL            time.Duration
-	NamespaceSelector   string
+	NamespaceSelector   string   // Namespace label selector
+	NamespaceInclude    []string // Namespaces always included
+	NamespaceExclude    []string // Namespaces always excluded
+	AppDiscoveryEnabled bool     // If false, only nodes are discovered
 	PreferCRD           bool
 	FallbackWorkloads   bool
 	CRDOnly             bool // If true, only discover from CRDs, ignore workloads
+	AppVersionStatus    bool // If true, write observedAt, matched workloads and conditions to AppVersion status
+	GenerateAppVersions bool // If true, create, update and delete AppVersions mirroring discovered workloads
 	LogLevel            string
 	WorkloadKinds       []string
+	WorkloadResources   []string         // Custom workload kinds as Kind=group/version/resource[:template.path]
+	PodVersions         bool             // If true, report versions from running pods instead of workload templates
+	AppScope            string           // "cluster" to key apps by name, "namespace" to key them by namespace and name
+	PrimaryVersion      string           // Rule for choosing an app's Version: highest, replicas or crd
+	ImageName           string           // Which part of an image names the app: last, repository or regex
+	ImageNamePattern    string           // Regex matched against registry/repository in regex mode
+	ExtractionRules     []ExtractionRule // Rules for finding app names and versions, tried before the defaults
+	FailBeforeReady     bool             // If true, /cluster-info returns 503 until the first refresh succeeds
+	MaxStaleness        time.Duration    // If set, /cluster-info returns 503 once the snapshot is older than this
+	EmbedSources        bool             // If true, /cluster-info includes the status of each discovery source
+	HistorySize         int              // Version transitions kept, 0 disables the history
+	HistoryStore        string           // Where the history is persisted: memory, file or configmap
+	HistoryFile         string           // File the history is persisted to in file mode
+	HistoryConfigMap    string           // ConfigMap the history is persisted to in configmap mode, as [namespace/]name
+	Clusters            []string         // Member clusters in hub mode as name=context or name=URL, empty outside hub mode
+	ClusterName         string           // Name reported in the cluster identity
+	NodeDetails         bool             // Report conditions, resources, platform and taints of nodes
+	NodeRoleRules       []NodeRoleRule   // Rules assigning roles to nodes, tried before the defaults
+	NodeIPPreference    []string         // Address types for a node's IP in order of preference, as Type or Type/Family
+	APIGroups           []string         // API groups reported in the cluster identity when installed
 	MetricsEnabled      bool
 	HealthcheckMode     bool
 }
 
+// ExtractionRule describes where to find an app's name and version on a
+// workload. Rules are tried in order and the first that yields a name wins.
+type ExtractionRule struct {
+	// Name identifies the rule in the output
+	Name string `json:"name,omitempty"`
+	// Kinds and Namespaces limit the workloads the rule applies to, empty means all
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Container is a glob matched against container names to pick the
+	// container image sources read from, empty means the first container
+	Container string `json:"container,omitempty"`
+	// AppName and Version are tried in order until one yields a value
+	AppName []ValueSource `json:"appName"`
+	Version []ValueSource `json:"version,omitempty"`
+}
+
+// NodeRoleRule assigns a role to the nodes with a label or a taint. Every
+// matching rule adds its role to a node's roles, and the first decides its
+// primary role.
+type NodeRoleRule struct {
+	Role string `json:"role"`
+	// Label or Taint is the key a node must have
+	Label string `json:"label,omitempty"`
+	Taint string `json:"taint,omitempty"`
+	// Value, if set, must be the value of the label or taint
+	Value string `json:"value,omitempty"`
+	// Effect, if set, must be the effect of the taint
+	Effect string `json:"effect,omitempty"`
+}
+
+// ValueSource reads a value from a label, an annotation or the container
+// image, optionally transformed by a regex
+type ValueSource struct {
+	Label      string `json:"label,omitempty"`
+	Annotation string `json:"annotation,omitempty"`
+	Image      bool   `json:"image,omitempty"`
+	// Regex keeps the group named "value", else the first group, else the
+	// whole match. A value that does not match is skipped.
+	Regex string `json:"regex,omitempty"`
+}
+
 // ClusterCache holds cached cluster information
 type ClusterCache struct {
 	Data      *ClusterInfo
 	UpdatedAt time.Time
-	TTL       time.Duration
+	// ModifiedAt is when a refresh last changed the snapshot content
+	ModifiedAt time.Time
+	TTL        time.Duration
+	LastError  string                  // Error from the last failed refresh, cleared on success
+	Sources    map[string]SourceStatus // Outcome of each source, keyed by kind and name
+
+	// Serving policy, see Config
+	FailBeforeReady bool
+	MaxStaleness    time.Duration
+	EmbedSources    bool
 }
 
 // IsExpired checks if the cache is expired
@@ -24,7 +24,7 @@ function Build-Docker {
 
 function Test-Docker {
     Write-Host "Running tests in Docker..." -ForegroundColor Green
-    docker run --rm -v "${PWD}:/workspace" -w /workspace golang:1.21-alpine go test -v ./...
+    docker run --rm -v "${PWD}:/workspace" -w /workspace golang:1.22-alpine go test -v ./...
     if ($LASTEXITCODE -eq 0) {
         Write-Host "✅ Tests passed" -ForegroundColor Green
     } else {
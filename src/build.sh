@@ -78,7 +78,7 @@ function build_docker() {
 
 function test_docker() {
     echo "🧪 Running tests in Docker..."
-    docker run --rm -v "$(pwd):/workspace" -w /workspace golang:1.21-alpine go test -v ./...
+    docker run --rm -v "$(pwd):/workspace" -w /workspace golang:1.22-alpine go test -v ./...
     echo "✅ Tests passed"
 }
 